@@ -1,11 +1,11 @@
 package core
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
-	"net/http/fcgi"
 	"strings"
+	"sync"
 
 	"github.com/jzaikovs/core/loggy"
 )
@@ -30,6 +30,26 @@ type App struct {
 	name      string
 	subdomain bool
 	subs      map[string]*App
+
+	// NotFound is called when there is no route for request, if nil default handler is used
+	NotFound RouteFunc
+	// MethodNotAllowed is called when request path has routes but not for request method,
+	// if nil default handler is used
+	MethodNotAllowed RouteFunc
+	// OnError is called when route function panics or returns error (see ErrFunc),
+	// buffered output and headers set by route are discarded before call,
+	// if nil generic error is written as JSON with code 500, details of error are only logged
+	OnError func(context Context, err error)
+
+	middlewares    []Middleware
+	authenticators []Authenticator
+	cors           *CORS
+	reqAuth        bool // all routes require authorization, see BasicAuth
+
+	onStart    []func() error
+	onShutdown []func() error
+	mu         sync.Mutex
+	srv        *server
 }
 
 // Module is par of app, for each app there is module instance
@@ -47,27 +67,6 @@ func New(name string, subdomain bool) *App {
 	}
 }
 
-// Run function will initiaate default config load and start listening for requests
-func Run() {
-	loggy.Info.Println("Starting core...")
-
-	addr := fmt.Sprintf("%s:%d", DefaultConfig.Host, DefaultConfig.Port)
-
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		loggy.Error.Println(err)
-		return
-	}
-
-	loggy.Info.Println("Listening on:", addr)
-
-	if DefaultConfig.FCGI {
-		fcgi.Serve(l, APP)
-	} else {
-		http.Serve(l, APP)
-	}
-}
-
 // Sub is used to link application module to main application module
 func (app *App) Sub(name string, sub *App) {
 	if app.subs == nil {
@@ -76,17 +75,45 @@ func (app *App) Sub(name string, sub *App) {
 	app.subs[strings.ToLower(name)] = sub
 }
 
+// Use adds middlewares for all application routes, including sub-applications,
+// application middlewares are executed before router and route middlewares
+func (app *App) Use(middlewares ...Middleware) {
+	app.middlewares = append(app.middlewares, middlewares...)
+}
+
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if app.Config == nil {
 		app.Config = DefaultConfig
 	}
 
+	if policy := app.Config.SecureHeaders; policy != nil {
+		policy.limitReport(w, r)
+	}
+
 	input := newInput(app, r)
 	output := newOutput(w)
+	output.noBody = r.Method == "HEAD"
+	output.cookieDefaults = app.Config.cookieDefaults(app.Config.secure(r))
+	output.config = app.Config
+	input.server = context{input, output}
+
+	if policy := app.Config.SecureHeaders; policy != nil {
+		policy.apply(context{input, output})
+		if policy.isReport(r) {
+			logReport(context{input, output})
+			output.Flush()
+			return
+		}
+	}
 
 	loggy.Trace.Println(input.RequestURI())
 
-	if app.Route(context{input, output}) {
+	input.appMiddlewares = app.middlewares
+	input.appAuthenticators = app.authenticators
+	input.appCORS = app.cors
+	input.appReqAuth = app.reqAuth
+	status := app.Route(context{input, output})
+	if status == RouteFound {
 		return
 	}
 
@@ -99,18 +126,60 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// sub-app router will work as if it is main router
 			input.reqURI = "/" + strings.Join(parts[1:], "/")
 			loggy.Trace.Println("Executing module", parts[0], input.RequestURI())
-			if sub.Route(context{input, output}) {
+			input.appMiddlewares = append(app.middlewares[:len(app.middlewares):len(app.middlewares)], sub.middlewares...)
+			input.appAuthenticators = append(app.authenticators[:len(app.authenticators):len(app.authenticators)], sub.authenticators...)
+			if sub.cors != nil {
+				input.appCORS = sub.cors
+			}
+			input.appReqAuth = app.reqAuth || sub.reqAuth
+			switch sub.Route(context{input, output}) {
+			case RouteFound:
 				return
+			case RouteMethodNotAllowed:
+				status = RouteMethodNotAllowed
 			}
 			loggy.Trace.Println("module failed")
 		}
 	}
 
+	defer output.Flush()
+
+	if status == RouteMethodNotAllowed {
+		output.Response(Response_Method_Not_Allowed)
+		app.handler(app.MethodNotAllowed)(context{input, output})
+		return
+	}
+
 	if DefaultConfig.HandleContent {
 		//fs := http.FileServer(http.Dir("./www"))
 		//fs.ServeHTTP(w, r)
 
-		ServeFile(output, input.RequestURI())
+		if serveFile(output, input.RequestURI()) {
+			return
+		}
+	}
+
+	output.Response(Response_Not_Found)
+	app.handler(app.NotFound)(context{input, output})
+}
+
+// handler returns fn or default error handler if fn is nil
+func (app *App) handler(fn RouteFunc) RouteFunc {
+	if fn != nil {
+		return fn
+	}
+	return app.defaultErrorHandler
+}
+
+// defaultErrorHandler writes response code as JSON for AJAX requests or as simple HTML page
+func (app *App) defaultErrorHandler(context Context) {
+	code := context.ResponseCode()
+	text := http.StatusText(code)
+
+	if context.Ajax() {
+		context.WriteJSON(app.Config.err_object_func(code, errors.New(strings.ToLower(text))))
+		return
 	}
-	return
+
+	context.WriteString(fmt.Sprintf("<!DOCTYPE html><html><head><title>%d %s</title></head><body><h1>%d %s</h1></body></html>", code, text, code, text))
 }
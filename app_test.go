@@ -2,14 +2,33 @@ package core
 
 import (
 	"bytes"
+	gocontext "context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jzaikovs/core/loggy"
+	"github.com/jzaikovs/core/session"
 	. "github.com/jzaikovs/t"
 )
 
@@ -28,6 +47,16 @@ func init() {
 	testServerURL = fmt.Sprintf("http://%s:%d", DefaultConfig.Host, DefaultConfig.Port)
 
 	go Run()
+
+	// wait until test server accepts connections, so tests don't race with server start
+	for i := 0; i < 250; i++ {
+		if conn, err := net.Dial("tcp", strings.TrimPrefix(testServerURL, "http://")); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	panic("core: test server did not start")
 }
 
 func _get(t *testing.T, url string) *http.Response {
@@ -109,15 +138,15 @@ func TestBasic(t *testing.T) {
 	}
 
 	resp := _get(t, `http://127.0.0.1:8080/limit`)
-	if val := resp.Header.Get(Header_X_Rate_Limit_Limit); val != "5" {
+	if val := resp.Header.Get(HeaderXRateLimit); val != "5" {
 		t.Fatal("Ratelimit not working", val)
 	}
 
-	if val := resp.Header.Get(Header_X_Rate_Limit_Remaining); val != "4" {
+	if val := resp.Header.Get(HeaderXRateLimitRemaining); val != "4" {
 		t.Fatal("Ratelimit not working #1", val)
 	}
 	resp = _get(t, `http://127.0.0.1:8080/limit`)
-	if val := resp.Header.Get(Header_X_Rate_Limit_Remaining); val != "3" {
+	if val := resp.Header.Get(HeaderXRateLimitRemaining); val != "3" {
 		t.Fatal("Ratelimit not working #2", val)
 	}
 
@@ -158,3 +187,403 @@ func TestBasic(t *testing.T) {
 		t.Fatal("Session().IsAuth() not working")
 	}
 }
+
+func TestNotFound(t *testing.T) {
+	c := newTestClient()
+
+	APP.Post(`/notallowed`, simple_resp("post"))
+
+	assert_s(t, c.get("/notallowed"), "405:<!DOCTYPE html><html><head><title>405 Method Not Allowed</title></head><body><h1>405 Method Not Allowed</h1></body></html>", "Bad method not allowed response")
+	assert_s(t, c.post("/notfound", Map{}), `404:{"code":404,"error":"not found"}`, "Bad not found response")
+
+	resp := _get(t, testServerURL+"/notallowed")
+	assert_s(t, resp.Header.Get("Allow"), "OPTIONS, POST", "Bad Allow header")
+}
+
+func TestShutdown(t *testing.T) {
+	app := New("shutdown", false)
+	app.Config = newConfigStruct()
+
+	started := make(chan bool)
+	app.Get(`/slow`, func(context Context) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		context.WriteString("done")
+	})
+
+	hooks := ""
+	app.OnStart(func() error { hooks += "start,"; return nil })
+	app.OnShutdown(func() error { hooks += "shutdown"; return nil })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	stopped := make(chan error)
+	go func() { stopped <- app.serve(ctx, l) }()
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		p, _ := ioutil.ReadAll(resp.Body)
+		body <- string(p)
+	}()
+
+	<-started
+	cancel() // shutdown while request is in-flight
+
+	assert_s(t, <-body, "done", "In-flight request not finished")
+	assert(t, <-stopped == nil, "Shutdown failed")
+	assert_s(t, hooks, "start,shutdown", "Hooks not called")
+}
+
+func TestStartFailure(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	app := New("startfail", false)
+	app.Config = newConfigStruct()
+	app.Config.TLSCert, app.Config.TLSKey = writeTestCert(t, t.TempDir())
+	app.Config.RedirectPort = busy.Addr().(*net.TCPAddr).Port
+
+	hooks := ""
+	app.OnStart(func() error { hooks += "start,"; return nil })
+	app.OnShutdown(func() error { hooks += "shutdown"; return nil })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, app.serve(gocontext.Background(), l) != nil, "Busy redirect port not reported")
+	assert_s(t, hooks, "", "OnStart called before listeners were opened")
+
+	app.Config.RedirectPort = 0
+	app.Config.FCGI = true
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, app.serve(gocontext.Background(), l) != nil, "TLS with FastCGI accepted")
+	assert_s(t, hooks, "", "OnStart called for invalid configuration")
+}
+
+// writeTestCert writes self-signed certificate for localhost to dir
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	app := New("tls", false)
+	app.Config = newConfigStruct()
+	app.Config.TLSCert, app.Config.TLSKey = writeTestCert(t, t.TempDir())
+	app.Config.SecureHeaders = &SecureHeaders{HSTSMaxAge: 3600, HSTSIncludeSubdomains: true}
+
+	app.Get(`/secure`, simple_resp("secure"))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+	go app.serve(ctx, l)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + l.Addr().String() + "/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, _read_cmp(resp.Body, "secure"), "Bad HTTPS response")
+	assert_s(t, resp.Header.Get("Strict-Transport-Security"), "max-age=3600; includeSubDomains", "Bad HSTS header")
+
+	// TLS terminated by proxy, X-Forwarded-Proto is trusted only from configured proxies
+	proxied := func(proto string) string {
+		req := httptest.NewRequest("GET", "/secure", nil)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec.Header().Get("Strict-Transport-Security")
+	}
+	assert_s(t, proxied("https"), "", "X-Forwarded-Proto trusted from unknown proxy")
+	app.Config.TrustedProxies = []string{"192.0.2.1"} // remote address of httptest requests
+	assert_s(t, proxied("https"), "max-age=3600; includeSubDomains", "Bad HSTS header behind proxy")
+	assert_s(t, proxied("http"), "", "HSTS header sent for plain HTTP request")
+
+	rec := httptest.NewRecorder()
+	redirectHandler(8443).ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com:8080/a?b=c", nil))
+	assert(t, rec.Code == http.StatusMovedPermanently, "Redirect not permanent")
+	assert_s(t, rec.Header().Get("Location"), "https://example.com:8443/a?b=c", "Bad redirect location")
+}
+
+func TestSecureHeaders(t *testing.T) {
+	app := New("secure", false)
+	app.Config = newConfigStruct()
+	app.Config.SecureHeaders = &SecureHeaders{
+		CSP:                "script-src 'self' 'nonce-{nonce}'",
+		ReportURI:          "/csp-report",
+		FrameOptions:       "DENY",
+		ContentTypeOptions: true,
+		ReferrerPolicy:     "same-origin",
+	}
+
+	tmpl := template.Must(template.New("page").Parse(`{{.csp_nonce}}`))
+	app.Get(`/page`, func(context Context) {
+		context.Render(tmpl, nil)
+	})
+	app.Get(`/embed`, simple_resp("embed")).SecureHeaders(&SecureHeaders{FrameOptions: "SAMEORIGIN"})
+
+	do := func(method, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(method, query, strings.NewReader(`{"csp-report":{}}`)))
+		return rec
+	}
+
+	rec := do("GET", "/page")
+	nonce := rec.Body.String()
+	assert(t, len(nonce) > 0, "Nonce not passed to template")
+	assert(t, len(rec.Header()["Set-Cookie"]) == 0, "Session created for rendering")
+	assert_s(t, rec.Header().Get("Content-Security-Policy"), "script-src 'self' 'nonce-"+nonce+"'; report-uri /csp-report", "Bad CSP header")
+	assert_s(t, rec.Header().Get("X-Frame-Options"), "DENY", "Bad X-Frame-Options header")
+	assert_s(t, rec.Header().Get("X-Content-Type-Options"), "nosniff", "Bad X-Content-Type-Options header")
+	assert_s(t, rec.Header().Get("Referrer-Policy"), "same-origin", "Bad Referrer-Policy header")
+	assert(t, do("GET", "/page").Body.String() != nonce, "Nonce reused")
+
+	rec = do("GET", "/embed")
+	assert_s(t, rec.Header().Get("X-Frame-Options"), "SAMEORIGIN", "Route policy not applied")
+	assert_s(t, rec.Header().Get("Content-Security-Policy"), "", "Application policy not replaced")
+
+	assert(t, do("POST", "/csp-report").Code == http.StatusNoContent, "Violation report not accepted")
+
+	// report is quoted in log and its size is limited
+	var log bytes.Buffer
+	loggy.Warning.SetOutput(&log)
+	defer loggy.Warning.SetOutput(os.Stdout)
+	rec = httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest("POST", "/csp-report", strings.NewReader("x\nWARNING: forged"+strings.Repeat("x", 2*maxReportSize))))
+	assert(t, rec.Code == http.StatusNoContent, "Large violation report not accepted")
+	assert(t, strings.Count(log.String(), "\n") == 1 && strings.Contains(log.String(), `"x\nWARNING: forged`), "Violation report not quoted")
+	assert(t, log.Len() < maxReportSize+200, "Violation report size not limited")
+}
+
+func TestCookies(t *testing.T) {
+	app := New("cookies", false)
+	app.Config = newConfigStruct()
+	app.Config.CookieDomain = "example.com"
+	app.Config.CookieSameSite = "strict"
+
+	app.Get(`/set`, func(context Context) {
+		context.SetCookieValue("plain", "1")
+
+		opts := context.Cookie("custom", "2")
+		opts.MaxAge = 60
+		opts.Path = "/app"
+		opts.HttpOnly = false
+		context.SetCookie(opts)
+
+		context.DeleteCookie("old")
+		context.Session().Set("cookies", true)
+	})
+
+	req := httptest.NewRequest("GET", "/set", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	plain := cookies["plain"]
+	assert(t, plain != nil && plain.HttpOnly && plain.Secure && plain.SameSite == http.SameSiteStrictMode &&
+		plain.Domain == "example.com" && plain.Path == "/" && plain.MaxAge == 30*24*3600, "Cookie defaults not applied")
+
+	custom := cookies["custom"]
+	assert(t, custom != nil && !custom.HttpOnly && custom.MaxAge == 60 && custom.Path == "/app", "Cookie options not applied")
+
+	old := cookies["old"]
+	assert(t, old != nil && old.MaxAge < 0 && old.Value == "", "Cookie not deleted")
+
+	// session cookie uses defaults too
+	session := cookies["sid"]
+	assert(t, session != nil && session.HttpOnly && session.Secure, "Session cookie not secure")
+
+	// zero max age makes cookies which are removed when browser is closed
+	config := newConfigStruct()
+	path := filepath.Join(t.TempDir(), "config.json")
+	ioutil.WriteFile(path, []byte(`{"cookie_max_age": 0}`), 0600)
+	if err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, config.cookieDefaults(false).MaxAge == 0, "Zero cookie_max_age not applied")
+
+	ioutil.WriteFile(path, []byte(`{"cookie_same_site": "stict"}`), 0600)
+	assert(t, newConfigStruct().Load(path) != nil, "Unknown cookie_same_site accepted")
+}
+
+func TestSecureCookies(t *testing.T) {
+	oldKey := "MDEyMzQ1Njc4OWFiY2RlZg=="
+	newKey := "ZmVkY2JhOTg3NjU0MzIxMA=="
+	oldSigningKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	newSigningKey := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+
+	app := New("securecookies", false)
+	app.Config = newConfigStruct()
+	app.Config.CookieSigningKeys = []string{oldSigningKey}
+	app.Config.CookieEncryptionKeys = []string{oldKey}
+
+	app.Get(`/set`, func(context Context) {
+		context.SetSignedCookie("prefs", "dark")
+		context.SetEncryptedCookie("remember", "u1")
+	})
+	app.Get(`/get`, func(context Context) {
+		prefs, err1 := context.SignedCookieValue("prefs")
+		remember, err2 := context.EncryptedCookieValue("remember")
+		context.WriteString(fmt.Sprint(prefs, ",", remember, ",", err1, ",", err2))
+	})
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest("GET", "/set", nil))
+	cookies := rec.Result().Cookies()
+
+	get := func(cookies []*http.Cookie) string {
+		req := httptest.NewRequest("GET", "/get", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	assert_s(t, get(cookies), "dark,u1,<nil>,<nil>", "Signed and encrypted cookies not read")
+
+	// values written with old key are accepted after rotation
+	app.Config = newConfigStruct()
+	app.Config.CookieSigningKeys = []string{newSigningKey, oldSigningKey}
+	app.Config.CookieEncryptionKeys = []string{newKey, oldKey}
+	assert_s(t, get(cookies), "dark,u1,<nil>,<nil>", "Cookies not read after key rotation")
+
+	// signed values expire
+	app.Config.CookieSignedMaxAge = 1
+	keys, _ := app.Config.cookieKeys()
+	expired, _ := keys.sign("prefs", "dark", time.Now().Add(-2*time.Second))
+	_, err := keys.verify("prefs", expired, app.Config.signedCookieMaxAge(), time.Now())
+	assert(t, err == ErrCookieExpired, "Expired signed value accepted")
+
+	// short signing keys are rejected until configuration is fixed
+	app.Config.CookieSigningKeys = []string{oldKey}
+	_, err = app.Config.cookieKeys()
+	assert(t, err != nil, "Short signing key accepted")
+	app.Config.CookieSigningKeys = []string{newSigningKey}
+	_, err = app.Config.cookieKeys()
+	assert(t, err == nil, "Keys not parsed after configuration change")
+	app.Config.CookieSigningKeys = []string{newSigningKey, oldSigningKey}
+
+	var tampered []*http.Cookie
+	for _, cookie := range cookies {
+		c := *cookie
+		if c.Name == "prefs" {
+			c.Value = base64.RawURLEncoding.EncodeToString([]byte("light")) + c.Value[strings.IndexByte(c.Value, '.'):]
+		} else {
+			b := []byte(c.Value)
+			b[len(b)/2] ^= 1
+			c.Value = string(b)
+		}
+		tampered = append(tampered, &c)
+	}
+	tamperedErr := ErrCookieTampered.Error()
+	assert_s(t, get(tampered), ",,"+tamperedErr+","+tamperedErr, "Tampered cookies accepted")
+}
+
+func TestApplySession(t *testing.T) {
+	defer func(lifetime, idle time.Duration, bind bool) {
+		session.MaxLifetime, session.IdleTimeout, session.BindUserAgent = lifetime, idle, bind
+	}(session.MaxLifetime, session.IdleTimeout, session.BindUserAgent)
+
+	session.IdleTimeout = 5 * time.Minute
+	session.BindUserAgent = true
+
+	config := newConfigStruct()
+	config.SessionMaxLifetime = 60
+	if err := config.applySession(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, session.MaxLifetime == time.Minute, "Configured option not applied")
+	assert(t, session.IdleTimeout == 5*time.Minute && session.BindUserAgent, "Options set in code overwritten")
+}
+
+func TestConfigRedacted(t *testing.T) {
+	config := newConfigStruct()
+	config.Port = 8080
+	config.SessionCookieKeys = []string{"c2VjcmV0LXNlc3Npb24ta2V5LTEyMzQ1Njc4OTAxMg=="}
+	config.CookieSigningKeys = []string{"c2lnbmluZy1rZXk="}
+	config.CookieEncryptionKeys = []string{"ZW5jcnlwdGlvbi1rZXk="}
+
+	b, err := config.redacted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, !strings.Contains(string(b), config.SessionCookieKeys[0]), "Session cookie key logged")
+	assert(t, !strings.Contains(string(b), config.CookieSigningKeys[0]), "Cookie signing key logged")
+	assert(t, !strings.Contains(string(b), config.CookieEncryptionKeys[0]), "Cookie encryption key logged")
+	assert(t, strings.Contains(string(b), `"port": 8080`), "Configuration not logged")
+}
+
+func TestCookieSessionWrittenOnce(t *testing.T) {
+	store, err := session.NewCookieStore([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(store session.Store) { session.DefaultStore = store }(session.DefaultStore)
+	session.DefaultStore = store
+
+	app := New("cookiesession", false)
+	app.Config = newConfigStruct()
+	app.Get(`/set`, func(context Context) {
+		context.Session().Set("x", "y")
+	})
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest("GET", "/set", nil))
+	assert(t, len(rec.Result().Header["Set-Cookie"]) == 1, "Cookie session written more than once")
+}
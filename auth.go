@@ -0,0 +1,257 @@
+package core
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jzaikovs/core/session"
+)
+
+// ErrInvalidCredentials is returned by authenticators when request has credentials that are not valid
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator authenticates request using credentials sent with request, for example, bearer tokens,
+// authenticated request gets authorized session that is not stored, so ReqAuth, ReqRole and RateLimitAuth
+// work same as with cookie sessions. Credentials are checked by AuthenticateStep after RateLimit,
+// so credentials can't be guessed faster than anonymous limit allows, RateLimitAuth is checked after that
+type Authenticator interface {
+	// Authenticate returns principal of request, nil principal if request has no credentials
+	// that authenticator understands and error if credentials are not valid
+	Authenticate(context Context) (*session.Principal, error)
+}
+
+// Authenticate adds authenticators for all application routes, including sub-applications,
+// authenticators are tried in order before route session is used
+func (app *App) Authenticate(authenticators ...Authenticator) {
+	app.authenticators = append(app.authenticators, authenticators...)
+}
+
+// Authenticate adds authenticators for route, route authenticators are tried after application authenticators
+func (route *Route) Authenticate(authenticators ...Authenticator) *Route {
+	route.authenticators = append(route.authenticators, authenticators...)
+	route.options |= optionAuthenticate
+	return route
+}
+
+// requiresAuth returns true if route can't be accessed anonymously
+func (route *Route) requiresAuth(context Context) bool {
+	return route.authRequest || context.reqAuth() || len(route.roles) > 0 || len(route.permissions) > 0
+}
+
+// authenticate finds principal of request, first authenticator that recognizes credentials is used
+func (route *Route) authenticate(context Context) (*session.Principal, error) {
+	for _, list := range [][]Authenticator{context.authenticators(), route.authenticators} {
+		for _, authenticator := range list {
+			principal, err := authenticator.Authenticate(context)
+			if err != nil || principal != nil {
+				return principal, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// BearerToken returns token from "Authorization: Bearer" header
+func BearerToken(context Context) (string, bool) {
+	auth := context.HeaderValue("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, len(token) > 0
+}
+
+// APIKeyAuthenticator authenticates requests by API key sent in header or as bearer token
+type APIKeyAuthenticator struct {
+	// Header is name of header with API key, default is X-API-Key
+	Header string
+	// Lookup returns principal for API key, nil if key is not known
+	Lookup func(key string) (*session.Principal, error)
+}
+
+// APIKeys creates API key authenticator with fixed keys
+func APIKeys(keys map[string]*session.Principal) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{Lookup: func(key string) (*session.Principal, error) {
+		// all keys are compared, so time does not depend on which key matched
+		var found *session.Principal
+		for k, principal := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				found = principal
+			}
+		}
+		return found, nil
+	}}
+}
+
+func (auth *APIKeyAuthenticator) Authenticate(context Context) (*session.Principal, error) {
+	header := auth.Header
+	if len(header) == 0 {
+		header = "X-API-Key"
+	}
+
+	key := context.HeaderValue(header)
+	if len(key) == 0 {
+		token, ok := BearerToken(context)
+		if !ok || strings.Count(token, ".") == 2 {
+			return nil, nil // JWT is left for JWT authenticator
+		}
+		key = token
+	}
+
+	principal, err := auth.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+// JWTAuthenticator authenticates requests by JSON Web Token sent as bearer token,
+// tokens signed with HS256 are verified with Secret, signed with RS256 - with PublicKey.
+// Claim sub is used as user ID, roles and permissions claims as roles and permissions,
+// scope claim is added to permissions
+type JWTAuthenticator struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+	// if not empty, iss claim must be equal to Issuer and aud claim must contain Audience
+	Issuer   string
+	Audience string
+	// allowed clock difference when checking exp and nbf claims
+	Leeway time.Duration
+	// tokens without exp claim are rejected unless AllowNoExp is set
+	AllowNoExp bool
+	// if not zero, tokens issued (iat claim) more than MaxAge ago are rejected,
+	// tokens without iat claim are then rejected too
+	MaxAge time.Duration
+}
+
+type jwtClaims struct {
+	Subject     string          `json:"sub"`
+	Issuer      string          `json:"iss"`
+	Audience    json.RawMessage `json:"aud"`
+	Expires     int64           `json:"exp"`
+	NotBefore   int64           `json:"nbf"`
+	IssuedAt    int64           `json:"iat"`
+	AuthTime    int64           `json:"auth_time"`
+	Scope       string          `json:"scope"`
+	Roles       []string        `json:"roles"`
+	Permissions []string        `json:"permissions"`
+}
+
+func (auth *JWTAuthenticator) Authenticate(context Context) (*session.Principal, error) {
+	token, ok := BearerToken(context)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := jwtDecode(parts[0], &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	// algorithm must match configured key, so RS256 public key can't be used as HS256 secret
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && len(auth.Secret) > 0:
+		mac := hmac.New(sha256.New, auth.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, ErrInvalidCredentials
+		}
+	case header.Alg == "RS256" && auth.PublicKey != nil:
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(auth.PublicKey, crypto.SHA256, sum[:], signature) != nil {
+			return nil, ErrInvalidCredentials
+		}
+	default:
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims jwtClaims
+	if err := jwtDecode(parts[1], &claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if !auth.valid(&claims, time.Now()) {
+		return nil, ErrInvalidCredentials
+	}
+
+	principal := &session.Principal{
+		UserID:      claims.Subject,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+	}
+	if len(claims.Scope) > 0 {
+		principal.Permissions = append(principal.Permissions, strings.Fields(claims.Scope)...)
+	}
+	if claims.AuthTime > 0 {
+		principal.AuthTime = time.Unix(claims.AuthTime, 0)
+	} else if claims.IssuedAt > 0 {
+		principal.AuthTime = time.Unix(claims.IssuedAt, 0)
+	}
+	return principal, nil
+}
+
+// valid checks time, issuer and audience claims
+func (auth *JWTAuthenticator) valid(claims *jwtClaims, now time.Time) bool {
+	if claims.Expires == 0 && !auth.AllowNoExp {
+		return false
+	}
+	if claims.Expires > 0 && now.Add(-auth.Leeway).Unix() >= claims.Expires {
+		return false
+	}
+	if auth.MaxAge > 0 && (claims.IssuedAt == 0 || now.Add(-auth.MaxAge-auth.Leeway).Unix() > claims.IssuedAt) {
+		return false
+	}
+	if claims.NotBefore > 0 && now.Add(auth.Leeway).Unix() < claims.NotBefore {
+		return false
+	}
+	if len(auth.Issuer) > 0 && claims.Issuer != auth.Issuer {
+		return false
+	}
+	if len(auth.Audience) > 0 {
+		// audience is string or array of strings
+		var audience []string
+		if err := json.Unmarshal(claims.Audience, &audience); err != nil {
+			var single string
+			if err := json.Unmarshal(claims.Audience, &single); err != nil {
+				return false
+			}
+			audience = []string{single}
+		}
+		for _, aud := range audience {
+			if aud == auth.Audience {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func jwtDecode(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jzaikovs/core/session"
+)
+
+// signJWT creates token signed with HS256 secret or RS256 private key
+func signJWT(alg string, key interface{}, claims string) string {
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims))
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(signed))
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, sum[:])
+	}
+	return signed + "." + enc.EncodeToString(signature)
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	APP.Get(`/auth/me`, func(context Context) {
+		p := context.Principal()
+		context.WriteString(fmt.Sprint(p.UserID, p.Roles, p.Permissions))
+	}).ReqAuth().Authenticate(
+		&JWTAuthenticator{Secret: secret, PublicKey: &rsaKey.PublicKey, Audience: "api"},
+		APIKeys(map[string]*session.Principal{"key1": {UserID: "service"}}),
+	)
+
+	get := func(header, value string) string {
+		req, _ := http.NewRequest("GET", testServerURL+"/auth/me", nil)
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "ERR"
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == 200 && len(resp.Header["Set-Cookie"]) > 0 {
+			return "cookie set"
+		}
+		p, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Sprintf("%d:%s", resp.StatusCode, p)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := fmt.Sprintf(`{"sub":"u1","aud":["api"],"exp":%d,"roles":["admin"],"scope":"orders:read"}`, exp)
+
+	assert_s(t, get("", ""), "401:", "Request without credentials authorized")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("HS256", secret, claims)), "200:u1[admin] [orders:read]", "HS256 token not accepted")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("RS256", rsaKey, claims)), "200:u1[admin] [orders:read]", "RS256 token not accepted")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("HS256", []byte("other"), claims)), "401:", "Token with bad signature accepted")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("HS256", secret, `{"sub":"u1","aud":"api","exp":1}`)), "401:", "Expired token accepted")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("HS256", secret, fmt.Sprintf(`{"sub":"u1","aud":"web","exp":%d}`, exp))), "401:", "Token for other audience accepted")
+	assert_s(t, get("Authorization", "Bearer "+signJWT("HS256", secret, `{"sub":"u1","aud":"api"}`)), "401:", "Token without exp accepted")
+	assert_s(t, get("X-API-Key", "key1"), "200:service[] []", "API key not accepted")
+	assert_s(t, get("Authorization", "Bearer key1"), "200:service[] []", "API key as bearer token not accepted")
+	assert_s(t, get("X-API-Key", "key2"), "401:", "Unknown API key accepted")
+}
+
+func TestAuthenticateOptional(t *testing.T) {
+	APP.Get(`/auth/optional`, func(context Context) {
+		if p := context.Principal(); p != nil {
+			context.WriteString(p.UserID)
+			return
+		}
+		context.WriteString("anonymous")
+	}).Authenticate(&JWTAuthenticator{Secret: []byte("secret")})
+
+	APP.Get(`/auth/limited`, simple_resp("ok")).RateLimit(1, 60).BasicAuth("admin", BasicUsers(map[string]string{"root": "secret"}))
+	APP.Get(`/auth/keylimit`, simple_resp("ok")).Authenticate(APIKeys(map[string]*session.Principal{"key1": {UserID: "u1"}})).
+		RateLimit(100, 60).RateLimitAuth(1, 60)
+
+	do := func(query string, set func(req *http.Request)) string {
+		req, _ := http.NewRequest("GET", testServerURL+query, nil)
+		set(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "ERR"
+		}
+		defer resp.Body.Close()
+		p, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Sprintf("%d:%s", resp.StatusCode, p)
+	}
+
+	token := signJWT("HS256", []byte("secret"), fmt.Sprintf(`{"sub":"u1","exp":%d}`, time.Now().Add(time.Hour).Unix()))
+	assert_s(t, do("/auth/optional", func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }), "200:u1", "Token not accepted")
+	assert_s(t, do("/auth/optional", func(req *http.Request) { req.Header.Set("Authorization", "Bearer x.y.z") }), "200:anonymous", "Invalid token rejected on optional route")
+
+	// credentials are checked after rate limits
+	assert_s(t, do("/auth/limited", func(req *http.Request) { req.SetBasicAuth("root", "wrong") }), "401:", "Wrong password accepted")
+	assert_s(t, do("/auth/limited", func(req *http.Request) { req.SetBasicAuth("root", "secret") }), "429:", "Credentials checked before rate limit")
+
+	// requests authenticated by authenticator are limited by RateLimitAuth
+	key := func(req *http.Request) { req.Header.Set("X-API-Key", "key1") }
+	assert_s(t, do("/auth/keylimit", key), "200:ok", "API key not accepted")
+	assert_s(t, do("/auth/keylimit", key), "429:", "RateLimitAuth not applied to authenticated request")
+	assert_s(t, do("/auth/keylimit", func(req *http.Request) {}), "200:ok", "Anonymous request limited by RateLimitAuth")
+}
+
+func TestAppBasicAuth(t *testing.T) {
+	sub := New("basicapp", false)
+	sub.BasicAuth("app", BasicUsers(map[string]string{"root": "secret"}))
+	sub.Get(`/page`, func(context Context) {
+		context.WriteString(context.Principal().UserID)
+	})
+	APP.Sub("basicapp", sub)
+
+	req, _ := http.NewRequest("GET", testServerURL+"/basicapp/page", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == 401 && len(resp.Header.Get("WWW-Authenticate")) > 0, "Application credentials not required")
+
+	req.SetBasicAuth("root", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, _read_cmp(resp.Body, "root"), "Application credentials not accepted")
+}
+
+func TestBasicAuth(t *testing.T) {
+	APP.Get(`/basic`, func(context Context) {
+		context.WriteString(context.Principal().UserID)
+	}).BasicAuth("admin", BasicUsers(map[string]string{"root": "secret"}))
+
+	get := func(user, password string) string {
+		req, _ := http.NewRequest("GET", testServerURL+"/basic", nil)
+		if len(user) > 0 {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "ERR"
+		}
+		defer resp.Body.Close()
+		p, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Sprintf("%d:%s:%s", resp.StatusCode, resp.Header.Get("WWW-Authenticate"), p)
+	}
+
+	assert_s(t, get("", ""), `401:Basic realm="admin", charset="UTF-8":`, "Missing credentials not challenged")
+	assert_s(t, get("root", "wrong"), `401:Basic realm="admin", charset="UTF-8":`, "Wrong password accepted")
+	assert_s(t, get("other", "secret"), `401:Basic realm="admin", charset="UTF-8":`, "Unknown user accepted")
+	assert_s(t, get("root", "secret"), "200::root", "Credentials not accepted")
+}
+
+func TestDigestAuth(t *testing.T) {
+	digest := NewDigestAuthenticator("admin", func(user, realm string) (string, bool) {
+		return DigestHA1("root", realm, "secret"), user == "root"
+	})
+
+	APP.Get(`/digest`, func(context Context) {
+		context.WriteString(context.Principal().UserID)
+	}).Authenticate(digest).ReqAuth()
+
+	resp, err := http.Get(testServerURL + "/digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	params := parseAuthParams(strings.TrimPrefix(resp.Header.Get("WWW-Authenticate"), "Digest "))
+	assert(t, resp.StatusCode == 401 && len(params["nonce"]) > 0, "Digest challenge not sent")
+
+	get := func(password, uri, nc string) int {
+		ha1 := DigestHA1("root", "admin", password)
+		ha2 := md5.Sum([]byte("GET:" + uri))
+		response := md5.Sum([]byte(ha1 + ":" + params["nonce"] + ":" + nc + ":abc:auth:" + hex.EncodeToString(ha2[:])))
+
+		req, _ := http.NewRequest("GET", testServerURL+"/digest", nil)
+		req.Header.Set("Authorization", fmt.Sprintf(`Digest username="root", realm="admin", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="abc", response="%s"`,
+			params["nonce"], uri, nc, hex.EncodeToString(response[:])))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert(t, get("wrong", "/digest", "00000001") == 401, "Wrong digest password accepted")
+	assert(t, get("secret", "/digest", "00000001") == 200, "Digest credentials not accepted")
+	assert(t, get("secret", "/digest", "00000001") == 401, "Replayed digest request accepted")
+	assert(t, get("secret", "/digest", "00000002") == 200, "Next nonce count not accepted")
+	assert(t, get("secret", "/other", "00000003") == 401, "Digest for other URI accepted")
+}
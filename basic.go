@@ -0,0 +1,238 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jzaikovs/core/session"
+)
+
+// Challenger is implemented by authenticators which ask client for credentials
+// with WWW-Authenticate header when request is not authorized
+type Challenger interface {
+	Challenge() string
+}
+
+// challenge adds WWW-Authenticate headers of application and route authenticators to response
+func (route *Route) challenge(context Context) {
+	for _, list := range [][]Authenticator{context.authenticators(), route.authenticators} {
+		for _, authenticator := range list {
+			if challenger, ok := authenticator.(Challenger); ok {
+				context.Header().Add("WWW-Authenticate", challenger.Challenge())
+			}
+		}
+	}
+}
+
+// BasicAuthenticator authenticates requests using HTTP Basic authentication
+type BasicAuthenticator struct {
+	Realm string
+	// Verify checks user name and password, use constant time comparison, see BasicUsers
+	Verify func(user, password string) bool
+}
+
+// BasicUsers returns verify function for fixed users and passwords, passwords are compared in constant time
+func BasicUsers(users map[string]string) func(user, password string) bool {
+	return func(user, password string) bool {
+		expected, ok := users[user]
+		if !ok {
+			expected = password + "x" // keep comparison for unknown user
+		}
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1 && ok
+	}
+}
+
+func (auth *BasicAuthenticator) Authenticate(context Context) (*session.Principal, error) {
+	user, password, ok := context.Request().BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if !auth.Verify(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &session.Principal{UserID: user, AuthTime: time.Now()}, nil
+}
+
+func (auth *BasicAuthenticator) Challenge() string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, auth.Realm)
+}
+
+// BasicAuth makes route accessible only with HTTP Basic credentials accepted by verify function
+func (route *Route) BasicAuth(realm string, verify func(user, password string) bool) *Route {
+	return route.Authenticate(&BasicAuthenticator{Realm: realm, Verify: verify}).ReqAuth()
+}
+
+// BasicAuth makes all routes in group accessible only with HTTP Basic credentials
+func (group *Group) BasicAuth(realm string, verify func(user, password string) bool) *Group {
+	return group.Authenticate(&BasicAuthenticator{Realm: realm, Verify: verify}).ReqAuth()
+}
+
+// BasicAuth makes all application routes accessible only with HTTP Basic credentials,
+// including sub-applications
+func (app *App) BasicAuth(realm string, verify func(user, password string) bool) {
+	app.Authenticate(&BasicAuthenticator{Realm: realm, Verify: verify})
+	app.reqAuth = true
+}
+
+// DigestAuthenticator authenticates requests using HTTP Digest authentication (RFC 7616, MD5, qop=auth),
+// nonces are signed and expire after NonceTTL, last nonce count of each used nonce is kept until nonce expires,
+// so captured requests can't be replayed
+type DigestAuthenticator struct {
+	Realm string
+	// HA1 returns hex encoded MD5 of "user:realm:password" for user, false if user is not known
+	HA1 func(user, realm string) (string, bool)
+	// NonceTTL is how long nonce is accepted
+	NonceTTL time.Duration
+
+	secret []byte
+
+	mu     sync.Mutex
+	counts map[string]uint64 // last nonce count for used nonces
+	swept  time.Time         // when expired nonces were removed from counts
+}
+
+// NewDigestAuthenticator creates Digest authenticator with random nonce signing key
+func NewDigestAuthenticator(realm string, ha1 func(user, realm string) (string, bool)) *DigestAuthenticator {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("core: can't generate digest secret: " + err.Error())
+	}
+	return &DigestAuthenticator{Realm: realm, HA1: ha1, NonceTTL: 5 * time.Minute, secret: secret}
+}
+
+// DigestHA1 returns HA1 value for user, realm and password, it can be stored instead of password
+func DigestHA1(user, realm, password string) string {
+	sum := md5.Sum([]byte(user + ":" + realm + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (auth *DigestAuthenticator) Authenticate(context Context) (*session.Principal, error) {
+	header := context.HeaderValue("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Digest ") {
+		return nil, nil
+	}
+
+	params := parseAuthParams(header[7:])
+	user := params["username"]
+
+	// digest covers uri from header, so it must be URI of this request
+	if params["realm"] != auth.Realm || params["qop"] != "auth" || params["uri"] != context.Request().RequestURI ||
+		!auth.validNonce(params["nonce"]) {
+		return nil, ErrInvalidCredentials
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 32)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	ha1, ok := auth.HA1(user, auth.Realm)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	ha2 := md5.Sum([]byte(context.Method() + ":" + params["uri"]))
+	expected := md5.Sum([]byte(strings.Join([]string{
+		ha1, params["nonce"], params["nc"], params["cnonce"], "auth", hex.EncodeToString(ha2[:]),
+	}, ":")))
+
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(expected[:])), []byte(params["response"])) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	if !auth.count(params["nonce"], nc, time.Now()) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &session.Principal{UserID: user, AuthTime: time.Now()}, nil
+}
+
+func (auth *DigestAuthenticator) Challenge() string {
+	return fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=MD5, nonce=%q`, auth.Realm, auth.nonce(time.Now()))
+}
+
+// nonce is creation time signed with secret
+func (auth *DigestAuthenticator) nonce(t time.Time) string {
+	b := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(t.Unix()))
+	mac := hmac.New(sha256.New, auth.secret)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+func (auth *DigestAuthenticator) validNonce(nonce string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return false
+	}
+	created := time.Unix(int64(binary.BigEndian.Uint64(b[:8])), 0)
+	if time.Since(created) > auth.NonceTTL {
+		return false
+	}
+	return hmac.Equal([]byte(nonce), []byte(auth.nonce(created)))
+}
+
+// count records nonce count for nonce, returns false if count is not greater than last count for nonce,
+// counts of expired nonces are removed once in NonceTTL
+func (auth *DigestAuthenticator) count(nonce string, nc uint64, now time.Time) bool {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if auth.counts == nil {
+		auth.counts = make(map[string]uint64)
+	}
+	if now.Sub(auth.swept) > auth.NonceTTL {
+		for used := range auth.counts {
+			if !auth.validNonce(used) {
+				delete(auth.counts, used)
+			}
+		}
+		auth.swept = now
+	}
+
+	if last, ok := auth.counts[nonce]; ok && nc <= last {
+		return false
+	}
+	auth.counts[nonce] = nc
+	return true
+}
+
+// parseAuthParams parses comma separated key=value and key="value" pairs of Authorization header
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				break
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -1,10 +1,18 @@
 package core
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jzaikovs/core/loggy"
+	"github.com/jzaikovs/core/session"
 	"github.com/jzaikovs/t"
 )
 
@@ -18,6 +26,69 @@ type configStruct struct {
 	Views         map[string]string `json:"views"`
 	Data          t.Map             `json:"data"`
 
+	// http server limits, timeouts are in seconds, zero means no timeout
+	ReadTimeout       int `json:"read_timeout"`
+	ReadHeaderTimeout int `json:"read_header_timeout"`
+	WriteTimeout      int `json:"write_timeout"`
+	IdleTimeout       int `json:"idle_timeout"`
+	MaxHeaderBytes    int `json:"max_header_bytes"`
+	// time to wait for in-flight requests on shutdown
+	ShutdownTimeout int `json:"shutdown_timeout"`
+
+	// TLS certificate and key files, if set server serves HTTPS,
+	// certificates are reloaded from disk on SIGHUP
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	HTTP2   bool   `json:"http2"`
+	// port for plain HTTP listener which redirects all requests to HTTPS, zero disables listener,
+	// Strict-Transport-Security header is set in secure_headers
+	RedirectPort int `json:"redirect_port"`
+
+	// session absolute lifetime and idle timeout in seconds, expired sessions are removed
+	// every session_gc_interval seconds. Session options which are not set in configuration
+	// keep values of session package, so they can be set in code
+	SessionMaxLifetime int `json:"session_max_lifetime"`
+	SessionIdleTimeout int `json:"session_idle_timeout"`
+	SessionGCInterval  int `json:"session_gc_interval"`
+	// number of random bytes in session ID
+	SessionIDLength int `json:"session_id_length"`
+	// binds sessions to client user agent and/or IP address
+	SessionBindUserAgent  *bool `json:"session_bind_user_agent"`
+	SessionBindRemoteAddr *bool `json:"session_bind_remote_addr"`
+	// base64 encoded AES keys (16, 24 or 32 bytes), if set sessions are kept in encrypted cookies
+	// instead of session store, first key encrypts, others are accepted for key rotation
+	SessionCookieKeys []string `json:"session_cookie_keys"`
+
+	// default cookie attributes, cookie_max_age in seconds (default 30 days, zero makes session cookies),
+	// cookie_path default is "/", cookies are Secure for HTTPS requests and HttpOnly unless set otherwise,
+	// cookie_same_site is lax (default), strict or none
+	CookieMaxAge   *int   `json:"cookie_max_age"`
+	CookieDomain   string `json:"cookie_domain"`
+	CookiePath     string `json:"cookie_path"`
+	CookieSecure   *bool  `json:"cookie_secure"`
+	CookieHTTPOnly *bool  `json:"cookie_http_only"`
+	CookieSameSite string `json:"cookie_same_site"`
+
+	// security headers added to all responses, can be replaced for route with Route.SecureHeaders
+	SecureHeaders *SecureHeaders `json:"secure_headers"`
+
+	// origins (scheme://host[:port]) allowed for CSRFProtect routes besides request host
+	CSRFTrustedOrigins []string `json:"csrf_trusted_origins"`
+
+	// IP addresses of proxies which terminate TLS, requests from them with "X-Forwarded-Proto: https"
+	// are handled as HTTPS requests, so HSTS header is sent and cookies are secure
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// base64 encoded keys for signed cookies (at least 32 bytes) and encrypted cookies
+	// (AES 16, 24 or 32 bytes), first key signs or encrypts, other keys are accepted for key rotation
+	CookieSigningKeys    []string `json:"cookie_signing_keys"`
+	CookieEncryptionKeys []string `json:"cookie_encryption_keys"`
+	// seconds for which signed cookie values are accepted after they are written (default 30 days)
+	CookieSignedMaxAge int `json:"cookie_signed_max_age"`
+
+	keysMu sync.Mutex // cookie keys are parsed on first use
+	keys   *cookieKeys
+
 	err_object_func func(code int, err error) interface{}
 }
 
@@ -37,6 +108,27 @@ func newConfigStruct() *configStruct {
 	return this
 }
 
+// secure returns true for HTTPS requests, including requests forwarded by trusted proxies
+func (config *configStruct) secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	for _, proxy := range config.TrustedProxies {
+		if proxy == host {
+			return true
+		}
+	}
+	return false
+}
+
 // Load is function for loading configuration from json file specified by path parameter.
 func (config *configStruct) Load(path string) error {
 	// default configurations
@@ -49,6 +141,22 @@ func (config *configStruct) Load(path string) error {
 		config.Host = "0.0.0.0"
 	}
 
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = 10
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 120
+	}
+
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 30
+	}
+
+	if config.SessionGCInterval == 0 {
+		config.SessionGCInterval = 60
+	}
+
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
@@ -58,7 +166,12 @@ func (config *configStruct) Load(path string) error {
 	}
 	loggy.Info.Println("Configuration loaded from file:", path)
 
-	b, err := json.MarshalIndent(config, "", "  ")
+	if err = config.checkCookies(); err != nil {
+		loggy.Error.Println(path+":", err)
+		return err
+	}
+
+	b, err := config.redacted()
 	if err != nil {
 		loggy.Info.Println(err)
 	}
@@ -68,6 +181,66 @@ func (config *configStruct) Load(path string) error {
 	return nil
 }
 
+// secretOptions are configuration options which values are not logged
+var secretOptions = []string{"session_cookie_keys", "cookie_signing_keys", "cookie_encryption_keys"}
+
+// redacted returns configuration as JSON with values of secret options replaced
+func (config *configStruct) redacted() ([]byte, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for _, name := range secretOptions {
+		if m[name] != nil {
+			m[name] = "[redacted]"
+		}
+	}
+	return json.MarshalIndent(m, "", "  ")
+}
+
 func (config *configStruct) SetRESTErrObjectFunc(fn func(code int, err error) interface{}) {
 	config.err_object_func = fn
 }
+
+// applySession sets session package options which are set in configuration
+func (config *configStruct) applySession() error {
+	if config.SessionMaxLifetime > 0 {
+		session.MaxLifetime = time.Duration(config.SessionMaxLifetime) * time.Second
+	}
+	if config.SessionIdleTimeout > 0 {
+		session.IdleTimeout = time.Duration(config.SessionIdleTimeout) * time.Second
+	}
+	if config.SessionBindUserAgent != nil {
+		session.BindUserAgent = *config.SessionBindUserAgent
+	}
+	if config.SessionBindRemoteAddr != nil {
+		session.BindRemoteAddr = *config.SessionBindRemoteAddr
+	}
+	if config.SessionIDLength > 0 {
+		session.IDLength = config.SessionIDLength
+	}
+
+	if len(config.SessionCookieKeys) == 0 {
+		return nil
+	}
+
+	keys := make([][]byte, len(config.SessionCookieKeys))
+	for i, key := range config.SessionCookieKeys {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("session_cookie_keys: %v", err)
+		}
+		keys[i] = b
+	}
+
+	store, err := session.NewCookieStore(keys...)
+	if err != nil {
+		return fmt.Errorf("session_cookie_keys: %v", err)
+	}
+	session.DefaultStore = store
+	return nil
+}
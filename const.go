@@ -12,10 +12,12 @@ const (
 const (
 	Response_Ok                     = 200
 	Response_Created                = 201
+	Response_No_Content             = 204
 	Response_Bad_Request            = 400
 	Response_Unauthorized           = 401
 	Response_Forbidden              = 403
 	Response_Not_Found              = 404
+	Response_Method_Not_Allowed     = 405
 	Response_Unsupported_Media_Type = 415
 	Response_Unprocessable_Entity   = 422
 	Response_Too_Many_Requests      = 429
@@ -17,6 +17,14 @@ func init() {
 // ServeFile this is just for development, file handling (CDN) better done by nginx or other
 // TODO: there can be better alternative just to use http.FileServer
 func ServeFile(out Output, path string) {
+	if !serveFile(out, path) {
+		out.Response(Response_Not_Found)
+		out.Flush()
+	}
+}
+
+// serveFile writes file from www directory, returns false if there is no such file
+func serveFile(out Output, path string) bool {
 	if x, err := url.Parse(path); err == nil {
 		path = x.Path
 	}
@@ -25,12 +33,14 @@ func ServeFile(out Output, path string) {
 
 	f, err := os.OpenFile(filepath.Join("./www/", path), os.O_RDONLY, 0)
 	if err != nil {
-		out.Response(Response_Not_Found)
-		out.Flush()
-		return
+		return false
 	}
 	defer f.Close()
 
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		return false
+	}
+
 	out.Response(Response_Ok)
 	out.SetContentType(mime.TypeByExtension(filepath.Ext(f.Name())))
 
@@ -38,4 +48,5 @@ func ServeFile(out Output, path string) {
 	io.Copy(out, f)
 
 	out.Flush() // flush response header
+	return true
 }
@@ -1,13 +1,45 @@
 package core
 
+import (
+	"html/template"
+
+	"github.com/jzaikovs/t"
+)
+
 // Context interface combines input and output interfaces so that
 // RouteFunc accepts single parameter - Context
 type Context interface {
 	Input
 	Output
+
+	// Render executes template and writes result in output, session flash messages are added
+	// to template data under key "flashes" and are removed from session, CSRF token is added under key "csrf_token"
+	// and CSP nonce under key "csp_nonce", flashes and CSRF token are added only if request already has session
+	Render(tmpl *template.Template, data t.Map) error
 }
 
 type context struct {
 	Input
 	Output
 }
+
+// FlashesKey is key of template data which holds session flash messages
+const FlashesKey = "flashes"
+
+func (c context) Render(tmpl *template.Template, data t.Map) error {
+	if data == nil {
+		data = make(t.Map)
+	}
+	// rendering does not create session, forms must call CSRFToken to get token for new session
+	s := c.existingSession()
+	if _, ok := data[FlashesKey]; !ok && s != nil {
+		data[FlashesKey] = s.AllFlashes()
+	}
+	if _, ok := data[CSRFTokenKey]; !ok && s != nil {
+		data[CSRFTokenKey] = c.CSRFToken()
+	}
+	if _, ok := data[CSPNonceKey]; !ok {
+		data[CSPNonceKey] = c.CSPNonce()
+	}
+	return tmpl.Execute(c, data)
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieOptions describes cookie written with Output.SetCookie,
+// use Output.Cookie to get options with configuration defaults
+type CookieOptions struct {
+	Name  string
+	Value string
+	// MaxAge is cookie lifetime in seconds, zero makes session cookie, negative deletes cookie
+	MaxAge   int
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// cookieDefaults returns default cookie options from configuration, cookies are secure
+// for HTTPS requests unless cookie_secure is set, HttpOnly unless cookie_http_only is false
+func (config *configStruct) cookieDefaults(tls bool) CookieOptions {
+	defaults := CookieOptions{
+		MaxAge:   30 * 24 * 3600,
+		Domain:   config.CookieDomain,
+		Path:     config.CookiePath,
+		Secure:   tls,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if config.CookieMaxAge != nil {
+		defaults.MaxAge = *config.CookieMaxAge
+	}
+	if len(defaults.Path) == 0 {
+		defaults.Path = "/"
+	}
+	if config.CookieSecure != nil {
+		defaults.Secure = *config.CookieSecure
+	}
+	if config.CookieHTTPOnly != nil {
+		defaults.HttpOnly = *config.CookieHTTPOnly
+	}
+
+	switch strings.ToLower(config.CookieSameSite) {
+	case "", "lax":
+	case "strict":
+		defaults.SameSite = http.SameSiteStrictMode
+	case "none":
+		defaults.SameSite = http.SameSiteNoneMode
+		defaults.Secure = true // browsers reject SameSite=None cookies without Secure
+	}
+
+	return defaults
+}
+
+// checkCookies validates cookie options of configuration
+func (config *configStruct) checkCookies() error {
+	switch strings.ToLower(config.CookieSameSite) {
+	case "", "lax", "strict", "none":
+	default:
+		return fmt.Errorf("cookie_same_site: unknown value %q, use lax, strict or none", config.CookieSameSite)
+	}
+	if config.CookieMaxAge != nil && *config.CookieMaxAge < 0 {
+		return fmt.Errorf("cookie_max_age: negative value %d", *config.CookieMaxAge)
+	}
+	return nil
+}
+
+// Cookie returns cookie options with configuration defaults
+func (out *output) Cookie(name, value string) *CookieOptions {
+	opts := out.cookieDefaults
+	if len(opts.Path) == 0 {
+		opts = (&configStruct{}).cookieDefaults(false)
+	}
+	opts.Name, opts.Value = name, value
+	return &opts
+}
+
+// SetCookie writes cookie with options, empty path is replaced with "/"
+func (out *output) SetCookie(opts *CookieOptions) {
+	cookie := &http.Cookie{
+		Name:     opts.Name,
+		Value:    opts.Value,
+		Domain:   opts.Domain,
+		Path:     opts.Path,
+		MaxAge:   opts.MaxAge,
+		Secure:   opts.Secure,
+		HttpOnly: opts.HttpOnly,
+		SameSite: opts.SameSite,
+	}
+	if len(cookie.Path) == 0 {
+		cookie.Path = "/"
+	}
+
+	// Expires is set for old browsers which don't support Max-Age
+	if opts.MaxAge > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(opts.MaxAge) * time.Second)
+	} else if opts.MaxAge < 0 {
+		cookie.Expires = time.Unix(1, 0)
+	}
+
+	http.SetCookie(out.response, cookie)
+}
+
+// SetCookieValue writes cookie with configuration defaults
+func (out *output) SetCookieValue(name, value string) {
+	out.SetCookie(out.Cookie(name, value))
+}
+
+// DeleteCookie removes cookie from client, cookie domain and path are taken from configuration
+func (out *output) DeleteCookie(name string) {
+	opts := out.Cookie(name, "")
+	opts.MaxAge = -1
+	out.SetCookie(opts)
+}
@@ -0,0 +1,142 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CORS is cross-origin resource sharing policy, policy can be set on application, group or route,
+// route policy has priority over group policy and group policy over application policy.
+// Without policy no CORS headers are sent and cross-origin requests are blocked by browsers
+type CORS struct {
+	// AllowedOrigins lists allowed origins, "*" allows any origin,
+	// "https://*.example.com" allows all subdomains of example.com
+	AllowedOrigins []string
+	// AllowOrigin is called for origins that are not listed in AllowedOrigins
+	AllowOrigin func(origin string) bool
+	// AllowedMethods for preflight response, if empty methods from route table are used
+	AllowedMethods []string
+	// AllowedHeaders for preflight response, if empty only CORS-safelisted headers are allowed
+	AllowedHeaders []string
+	// ExposedHeaders are response headers that client script can read
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and authorization headers, it can't be used with "*" in AllowedOrigins
+	AllowCredentials bool
+	// MaxAge is time in seconds preflight response can be cached, zero does not send header
+	MaxAge int
+}
+
+// safelistedHeaders are CORS-safelisted request headers, they are allowed when policy has no AllowedHeaders
+var safelistedHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+
+// check panics if policy would allow any site to send credentialed requests,
+// same as for other invalid route configuration
+func (policy *CORS) check() {
+	if policy != nil && policy.AllowCredentials && contains(policy.AllowedOrigins, "*") {
+		panic(`core: CORS policy can't allow credentials for any origin "*"`)
+	}
+}
+
+// CORS sets CORS policy for all application routes, sub-application policy has priority
+func (app *App) CORS(policy *CORS) {
+	policy.check()
+	app.cors = policy
+}
+
+// CORS sets CORS policy for route
+func (route *Route) CORS(policy *CORS) *Route {
+	policy.check()
+	route.cors = policy
+	route.options |= optionCORS
+	return route
+}
+
+// CORS sets CORS policy for all routes in group
+func (group *Group) CORS(policy *CORS) *Group {
+	policy.check()
+	group.set(optionCORS, func(r *Route) { r.CORS(policy) })
+	return group
+}
+
+// corsPolicy returns policy of route or application
+func (route *Route) corsPolicy(context Context) *CORS {
+	if route.cors != nil {
+		return route.cors
+	}
+	return context.cors()
+}
+
+// allows checks if origin is allowed by policy
+func (policy *CORS) allows(origin string) bool {
+	for _, allowed := range policy.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// wildcard subdomain, scheme must match
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, domain := allowed[:i+3], allowed[i+4:]
+			if len(origin) > len(scheme)+len(domain) && strings.EqualFold(origin[:len(scheme)], scheme) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return policy.AllowOrigin != nil && policy.AllowOrigin(origin)
+}
+
+// apply adds CORS headers to response, returns false if request origin is not allowed
+func (policy *CORS) apply(context Context) bool {
+	context.Header().Add("Vary", "Origin")
+
+	origin := context.HeaderValue("Origin")
+	if len(origin) == 0 || !policy.allows(origin) {
+		return false
+	}
+
+	if contains(policy.AllowedOrigins, "*") {
+		context.AddHeader("Access-Control-Allow-Origin", "*")
+	} else {
+		context.AddHeader("Access-Control-Allow-Origin", origin)
+	}
+
+	if policy.AllowCredentials {
+		context.AddHeader("Access-Control-Allow-Credentials", "true")
+	}
+	if len(policy.ExposedHeaders) > 0 {
+		context.AddHeader("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+	}
+	return true
+}
+
+// preflight answers preflight request, allowed are methods of route table for request URI
+func (policy *CORS) preflight(context Context, allowed []string) {
+	context.Response(Response_No_Content)
+	context.Header().Add("Vary", "Access-Control-Request-Method")
+	context.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !policy.apply(context) {
+		return
+	}
+
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = allowed
+	}
+	context.AddHeader("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = safelistedHeaders
+	}
+	context.AddHeader("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+
+	if policy.MaxAge > 0 {
+		context.AddHeader("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+}
+
+// isPreflight returns true for CORS preflight request
+func isPreflight(context Context) bool {
+	return context.Method() == "OPTIONS" && len(context.HeaderValue("Origin")) > 0 &&
+		len(context.HeaderValue("Access-Control-Request-Method")) > 0
+}
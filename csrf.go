@@ -0,0 +1,157 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/jzaikovs/core/session"
+	"github.com/jzaikovs/t"
+)
+
+// CSRF synchronizer token names, token is sent in header or form field
+const (
+	HeaderXCSRFToken = "X-CSRF-Token"
+	CSRFField        = "_csrf_token"
+	// CSRFTokenKey is key of template data which holds masked CSRF token
+	CSRFTokenKey = "csrf_token"
+)
+
+// session data key for per-session CSRF secret
+const csrfSessionKey = "_csrf_secret"
+
+const csrfTokenLength = 32
+
+// CSRFProtect marks route to check synchronizer CSRF token, request with unsafe method must send token
+// returned by Context.CSRFToken in X-CSRF-Token header or _csrf_token field, and its Origin or Referer
+// must be same origin or listed in csrf_trusted_origins
+func (route *Route) CSRFProtect() *Route {
+	route.csrfProtect = true
+	route.options |= optionCSRFProtect
+	return route
+}
+
+// CSRFProtect sets CSRFProtect option for all routes in group
+func (group *Group) CSRFProtect() *Group {
+	group.set(optionCSRFProtect, func(r *Route) { r.CSRFProtect() })
+	return group
+}
+
+// CSRFToken returns CSRF token for session, token is masked with random pad,
+// so each call returns different value, but all of them are valid for session,
+// returns empty string if request has no session
+func (in *defaultInput) CSRFToken() string {
+	s := in.Session()
+	if s == nil {
+		return ""
+	}
+	secret := csrfSecret(s)
+
+	token := make([]byte, 2*csrfTokenLength)
+	if _, err := rand.Read(token[:csrfTokenLength]); err != nil {
+		panic("core: can't generate CSRF token: " + err.Error())
+	}
+	for i := 0; i < csrfTokenLength; i++ {
+		token[csrfTokenLength+i] = token[i] ^ secret[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// csrfSecret returns per-session CSRF secret, secret is created on first use,
+// secret is stored with session ID, so new secret is created when session gets new ID
+// on Authorize or Login and tokens issued before login are not valid
+func csrfSecret(s *session.Session) []byte {
+	var secret []byte
+	s.Update(func(data t.Map) {
+		if value, ok := data[csrfSessionKey].(string); ok && strings.HasSuffix(value, "."+s.ID()) {
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimSuffix(value, "."+s.ID()))
+			if err == nil && len(b) == csrfTokenLength {
+				secret = b
+				return
+			}
+		}
+		secret = make([]byte, csrfTokenLength)
+		if _, err := rand.Read(secret); err != nil {
+			panic("core: can't generate CSRF secret: " + err.Error())
+		}
+		data[csrfSessionKey] = base64.RawURLEncoding.EncodeToString(secret) + "." + s.ID()
+	})
+	return secret
+}
+
+// validCSRFToken unmasks token and compares it with session secret
+func validCSRFToken(context Context, token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*csrfTokenLength {
+		return false
+	}
+
+	s := context.Session()
+	if s == nil {
+		return false
+	}
+	secret := csrfSecret(s)
+	unmasked := make([]byte, csrfTokenLength)
+	for i := range unmasked {
+		unmasked[i] = b[i] ^ b[csrfTokenLength+i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+// sameOrigin checks Origin header or, if it is missing, Referer header, request without both is rejected,
+// request over plain HTTP can have HTTPS origin of same host, because TLS can be terminated by proxy
+func sameOrigin(context Context) bool {
+	req := context.Request()
+
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		referer := req.Header.Get("Referer")
+		if len(referer) == 0 {
+			return false
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	if strings.EqualFold(origin, "https://"+req.Host) || (req.TLS == nil && strings.EqualFold(origin, "http://"+req.Host)) {
+		return true
+	}
+
+	for _, trusted := range context.App().Config.CSRFTrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethod returns true for methods that must not change state
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// checkCSRF validates synchronizer token of request
+func checkCSRF(context Context) bool {
+	if safeMethod(context.Method()) {
+		return true
+	}
+
+	if !sameOrigin(context) {
+		return false
+	}
+
+	token := context.HeaderValue(HeaderXCSRFToken)
+	if len(token) == 0 {
+		token = context.Data().Str(CSRFField)
+	}
+	return len(token) > 0 && validCSRFToken(context, token)
+}
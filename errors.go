@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/jzaikovs/core/loggy"
+)
+
+// RouteErrFunc is route function that can return error,
+// returned error is handled same way as panic in route function
+type RouteErrFunc func(Context) error
+
+// ErrFunc converts RouteErrFunc to RouteFunc, so it can be used in routes
+func ErrFunc(fn RouteErrFunc) RouteFunc {
+	return func(context Context) {
+		if err := fn(context); err != nil {
+			loggy.Error.Println(context.RemoteAddr(), context.Method(), context.RequestURI(), err)
+			handleError(context, err)
+		}
+	}
+}
+
+// recoverRoute recovers from panic in route function, must be called using defer
+func recoverRoute(context Context) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+
+	loggy.Error.Printf("%s %s %s panic: %v\n%s", context.RemoteAddr(), context.Method(), context.RequestURI(), r, debug.Stack())
+	handleError(context, err)
+}
+
+// errInternal is error sent to client, details of error are only logged
+var errInternal = errors.New("internal server error")
+
+// handleError discards route output and calls application error handler
+func handleError(context Context, err error) {
+	if !context.discard() {
+		return // response is already written
+	}
+
+	context.Response(Response_Internal_Server_Error)
+
+	app := context.App()
+	if app.OnError != nil {
+		app.OnError(context, err)
+		return
+	}
+
+	context.WriteJSON(app.Config.err_object_func(Response_Internal_Server_Error, errInternal))
+}
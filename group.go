@@ -0,0 +1,275 @@
+package core
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// routeOption marks route options that can be inherited from group
+type routeOption uint
+
+const (
+	optionAuth routeOption = 1 << iota
+	optionRateLimit
+	optionRateLimitAuth
+	optionJSON
+	optionNoCache
+	optionCSRF
+	optionRole
+	optionPermission
+	optionAuthenticate
+	optionCSRFProtect
+	optionCORS
+	optionSecureHeaders
+	optionThrottle
+	optionAccessRedirect
+)
+
+// Group is router for routes with shared prefix, middlewares and route options,
+// routes are added to router from which group was created
+type Group struct {
+	router      *defaultRouter
+	parent      *Group
+	prefix      string
+	middlewares []Middleware
+	options     map[routeOption]func(*Route)
+	routes      []*Route // routes added in group, options set later are applied to them too
+	groups      []*Group // nested groups
+}
+
+func newGroup(router *defaultRouter, parent *Group, prefix string) *Group {
+	group := &Group{
+		router:  router,
+		parent:  parent,
+		prefix:  strings.TrimRight(prefix, "/"),
+		options: make(map[routeOption]func(*Route)),
+	}
+	if parent != nil {
+		parent.groups = append(parent.groups, group)
+	}
+	return group
+}
+
+// Group creates sub-router for routes with prefix, fn is called with created group
+// so routes can be added in it, options set on group are inherited by all its routes
+func (router *defaultRouter) Group(prefix string, fn func(Router)) *Group {
+	group := newGroup(router, nil, prefix)
+	if fn != nil {
+		fn(group)
+	}
+	return group
+}
+
+// Group creates nested group, nested group inherits prefix, middlewares and options of this group
+func (group *Group) Group(prefix string, fn func(Router)) *Group {
+	nested := newGroup(group.router, group, group.prefix+prefix)
+	if fn != nil {
+		fn(nested)
+	}
+	return nested
+}
+
+// pattern adds group prefix to route pattern
+func (group *Group) pattern(pattern string) string {
+	if strings.HasPrefix(pattern, "^") {
+		return "^" + regexp.QuoteMeta(group.prefix) + pattern[1:]
+	}
+	return group.prefix + pattern
+}
+
+func (group *Group) addRoute(method, pattern string, callback RouteFunc) *Route {
+	return group.add(group.router.addRoute(method, group.pattern(pattern), callback))
+}
+
+// add adds route in group, options already set on group and its parents are applied to route
+func (group *Group) add(route *Route) *Route {
+	route.group = group
+	group.routes = append(group.routes, route)
+
+	depth := 0
+	for g := group; g != nil; g = g.parent {
+		for option, apply := range g.options {
+			route.inherit(option, depth, apply)
+		}
+		depth++
+	}
+	return route
+}
+
+// set sets option of group and applies it to routes already added in group and nested groups,
+// roles, permissions and authenticators are added to ones set before
+func (group *Group) set(option routeOption, apply func(*Route)) {
+	if prev := group.options[option]; prev != nil && option&mergedOptions != 0 {
+		group.options[option] = func(r *Route) {
+			prev(r)
+			apply(r)
+		}
+	} else {
+		group.options[option] = apply
+	}
+	group.walk(0, func(route *Route, depth int) {
+		route.inherit(option, depth, apply)
+	})
+}
+
+// walk calls fn for all routes of group and its nested groups, depth is distance
+// from group in which route was added
+func (group *Group) walk(depth int, fn func(route *Route, depth int)) {
+	for _, route := range group.routes {
+		fn(route, depth)
+	}
+	for _, nested := range group.groups {
+		nested.walk(depth+1, fn)
+	}
+}
+
+// Route dispatches request using router from which group was created
+func (group *Group) Route(context Context) RouteStatus {
+	return group.router.Route(context)
+}
+
+// URL builds URL path for named route using router from which group was created
+func (group *Group) URL(name string, params ...interface{}) (string, error) {
+	return group.router.URL(name, params...)
+}
+
+// Use adds middlewares for all routes in group, group middlewares are executed
+// after router and parent group middlewares
+func (group *Group) Use(middlewares ...Middleware) {
+	group.middlewares = append(group.middlewares, middlewares...)
+}
+
+// Get adds router handler for GET request
+func (group *Group) Get(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("GET", pattern, callback)
+}
+
+// Post adds router for POST request
+func (group *Group) Post(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("POST", pattern, callback)
+}
+
+// Put adds router for PUT request
+func (group *Group) Put(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("PUT", pattern, callback)
+}
+
+// Delete adds router for DELETE request
+func (group *Group) Delete(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("DELETE", pattern, callback)
+}
+
+// Patch adds router for PATCH request
+func (group *Group) Patch(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("PATCH", pattern, callback)
+}
+
+// Head adds router for HEAD request
+func (group *Group) Head(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("HEAD", pattern, callback)
+}
+
+// Options adds router for OPTIONS request
+func (group *Group) Options(pattern string, callback RouteFunc) *Route {
+	return group.addRoute("OPTIONS", pattern, callback)
+}
+
+// Method adds router for request with specific method
+func (group *Group) Method(method, pattern string, callback RouteFunc) *Route {
+	return group.addRoute(strings.ToUpper(method), pattern, callback)
+}
+
+// Any adds single route for all methods
+func (group *Group) Any(pattern string, callback RouteFunc) *Route {
+	return group.add(group.router.Any(group.pattern(pattern), callback))
+}
+
+// Handle adds http.Handler with group prefix
+func (group *Group) Handle(pattern string, handler http.Handler) {
+	group.add(group.router.handle(group.pattern(pattern), handler))
+}
+
+// ReqAuth sets ReqAuth option for all routes in group
+func (group *Group) ReqAuth(args ...string) *Group {
+	group.set(optionAuth, func(r *Route) { r.ReqAuth(args...) })
+	return group
+}
+
+// RateLimit sets RateLimit option for all routes in group, each route has its own limits
+func (group *Group) RateLimit(rate, per float32) *Group {
+	group.set(optionRateLimit, func(r *Route) { r.RateLimit(rate, per) })
+	return group
+}
+
+// RateLimitAuth sets RateLimitAuth option for all routes in group, each route has its own limits
+func (group *Group) RateLimitAuth(rate, per float32) *Group {
+	group.set(optionRateLimitAuth, func(r *Route) { r.RateLimitAuth(rate, per) })
+	return group
+}
+
+// JSON sets JSON option for all routes in group
+func (group *Group) JSON() *Group {
+	group.set(optionJSON, func(r *Route) { r.JSON() })
+	return group
+}
+
+// NoCache sets NoCache option for all routes in group
+func (group *Group) NoCache() *Group {
+	group.set(optionNoCache, func(r *Route) { r.NoCache() })
+	return group
+}
+
+// ReqRole adds required role for all routes in group
+func (group *Group) ReqRole(role string, args ...string) *Group {
+	group.set(optionRole, func(r *Route) { r.ReqRole(role, args...) })
+	return group
+}
+
+// ReqPermission adds required permission for all routes in group
+func (group *Group) ReqPermission(permission string, args ...string) *Group {
+	group.set(optionPermission, func(r *Route) { r.ReqPermission(permission, args...) })
+	return group
+}
+
+// Authenticate adds authenticators for all routes in group
+func (group *Group) Authenticate(authenticators ...Authenticator) *Group {
+	group.set(optionAuthenticate, func(r *Route) { r.Authenticate(authenticators...) })
+	return group
+}
+
+// CSRF sets CSRF option for all routes in group
+func (group *Group) CSRF(emit, need bool) *Group {
+	group.set(optionCSRF, func(r *Route) { r.CSRF(emit, need) })
+	return group
+}
+
+// options with lists of values, values of groups are added to values of route
+const mergedOptions = optionRole | optionPermission | optionAuthenticate
+
+// inherit applies option of group at depth to route, unless it is set on route itself or by nearer group,
+// roles, permissions and authenticators of groups are added to route ones
+func (route *Route) inherit(option routeOption, depth int, apply func(*Route)) {
+	if route.inherited == nil {
+		route.inherited = make(map[routeOption]int)
+	}
+	if option&mergedOptions == 0 {
+		if nearest, ok := route.inherited[option]; route.options&option != 0 || ok && nearest < depth {
+			return
+		}
+		route.inherited[option] = depth
+	}
+
+	own, redirect := route.options, route.accessRedirect
+	apply(route)
+	route.options = own
+
+	// access redirect of route itself or nearer group has priority
+	if route.accessRedirect != redirect {
+		if nearest, ok := route.inherited[optionAccessRedirect]; own&optionAccessRedirect != 0 || ok && nearest < depth {
+			route.accessRedirect = redirect
+		} else {
+			route.inherited[optionAccessRedirect] = depth
+		}
+	}
+}
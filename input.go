@@ -29,6 +29,9 @@ type Input interface {
 	// wrapper for http.Request.FormValue
 	FormValue(string) string
 
+	// signed and encrypted cookies, see Output.SetSignedCookie and Output.SetEncryptedCookie
+	SignedCookieValue(name string) (string, error)
+	EncryptedCookieValue(name string) (string, error)
 	CookieValue(string) (string, bool)
 	// Return user agent
 	UserAgent() string
@@ -39,18 +42,39 @@ type Input interface {
 	Ajax() bool
 	// Access to URL parameters
 	Args(int) t.T
+	// Access to named URL parameters, returns empty value if there is no such parameter
+	Param(string) t.T
 	// Access to posted data
 	Data() t.Map
 	// Provides access to session data
 	Session() *session.Session
+	// Principal returns user of authorized session, nil if session is not authorized
+	Principal() *session.Principal
+	// CSRFToken returns masked CSRF token of session for CSRFProtect routes
+	CSRFToken() string
+	// CSPNonce returns random nonce of request for Content-Security-Policy
+	CSPNonce() string
 
 	// returns body content, JSON post with JSON as content-body
 	Body() (result string)
 
 	Request() *http.Request
 
-	linkArgs([]t.T)
+	// Builds URL for named route, see App.URL
+	URL(name string, params ...interface{}) (string, error)
+	// Returns route which is handling request
+	Route() *Route
+
+	linkArgs([]t.T, []string)
+	linkRoute(*Route)
+	middlewares() []Middleware
+	authenticators() []Authenticator
+	cors() *CORS
+	reqAuth() bool
 	linkSession(*session.Session)
+	linkedSession() *session.Session
+	existingSession() *session.Session
+	isAuth() bool
 	addData(string, interface{})
 }
 
@@ -58,11 +82,20 @@ type defaultInput struct {
 	app     *App
 	request *http.Request
 	args    []t.T
+	names   []string
+	route   *Route
 	session *session.Session
+	server  session.Server // context of request, used for creating session
 	data    t.Map
 	parsed  bool
 	body    []byte
 	reqURI  string
+	nonce   string // CSP nonce
+
+	appMiddlewares    []Middleware // middlewares of application and sub-application
+	appAuthenticators []Authenticator
+	appCORS           *CORS
+	appReqAuth        bool
 }
 
 func newInput(app *App, request *http.Request) (in *defaultInput) {
@@ -106,8 +139,33 @@ func (in *defaultInput) App() *App {
 	return in.app
 }
 
-func (in *defaultInput) linkArgs(args []t.T) {
+func (in *defaultInput) linkArgs(args []t.T, names []string) {
 	in.args = args
+	in.names = names
+}
+
+func (in *defaultInput) linkRoute(route *Route) {
+	in.route = route
+}
+
+func (in *defaultInput) Route() *Route {
+	return in.route
+}
+
+func (in *defaultInput) middlewares() []Middleware {
+	return in.appMiddlewares
+}
+
+func (in *defaultInput) authenticators() []Authenticator {
+	return in.appAuthenticators
+}
+
+func (in *defaultInput) cors() *CORS {
+	return in.appCORS
+}
+
+func (in *defaultInput) reqAuth() bool {
+	return in.appReqAuth
 }
 
 func (in *defaultInput) linkSession(session *session.Session) {
@@ -118,9 +176,52 @@ func (in *defaultInput) Args(idx int) t.T {
 	return in.args[idx]
 }
 
+func (in *defaultInput) Param(name string) t.T {
+	for i, n := range in.names {
+		if n == name && i < len(in.args) {
+			return in.args[i]
+		}
+	}
+	return t.T{}
+}
+
+// Session returns session of request, session is created on first use while request is routed,
+// so requests rejected before session is used don't get session and cookie
 func (in *defaultInput) Session() *session.Session {
+	if in.session == nil && in.route != nil && in.server != nil {
+		in.session = session.New(in.server)
+	}
+	return in.session
+}
+
+// linkedSession returns session of request if it is already created or loaded
+func (in *defaultInput) linkedSession() *session.Session {
+	return in.session
+}
+
+// existingSession returns session of request if it is created or client has one, without creating new session
+func (in *defaultInput) existingSession() *session.Session {
+	if in.session == nil && in.server != nil {
+		if s, ok := session.Find(in.server); ok {
+			in.session = s
+		}
+	}
 	return in.session
 }
+
+// isAuth checks if request has authorized session without creating new session
+func (in *defaultInput) isAuth() bool {
+	s := in.existingSession()
+	return s != nil && s.IsAuth()
+}
+
+func (in *defaultInput) Principal() *session.Principal {
+	if !in.isAuth() {
+		return nil
+	}
+	return in.session.Principal()
+}
+
 func (in *defaultInput) ContentType() string {
 	return in.HeaderValue("Content-Type")
 }
@@ -250,6 +351,10 @@ func (in *defaultInput) Request() *http.Request {
 	return in.request
 }
 
+func (in *defaultInput) URL(name string, params ...interface{}) (string, error) {
+	return in.app.URL(name, params...)
+}
+
 func (in *defaultInput) addData(k string, v interface{}) {
 	in.data[k] = v
 }
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/jzaikovs/core/loggy"
+	"github.com/jzaikovs/core/session"
+)
+
+// Middleware wraps route function, middleware can stop request handling
+// by not calling next function, for example, when request is not authorized
+type Middleware func(next RouteFunc) RouteFunc
+
+// Built-in route steps, these are middlewares that use options set on route (JSON, ReqAuth, CSRF, ...),
+// steps can be reordered or replaced globally using DefaultPipeline or for single route using Route.Pipeline
+var (
+	JSONStep         Middleware = jsonStep
+	RateLimitStep    Middleware = rateLimitStep
+	AuthenticateStep Middleware = authenticateStep
+	AuthStep         Middleware = authStep
+	CSRFStep         Middleware = csrfStep
+	NoCacheStep      Middleware = noCacheStep
+	RulesStep        Middleware = rulesStep
+)
+
+// DefaultPipeline is list of built-in steps executed for each route after user middlewares,
+// cheap checks go first, so rejected requests don't create sessions, and rate limits are checked
+// before credentials, so credentials can't be guessed faster than limits allow
+var DefaultPipeline = []Middleware{JSONStep, RateLimitStep, AuthenticateStep, AuthStep, CSRFStep, NoCacheStep, RulesStep}
+
+// wrap wraps function in middlewares, first middleware is outermost
+func wrap(fn RouteFunc, middlewares []Middleware) RouteFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		fn = middlewares[i](fn)
+	}
+	return fn
+}
+
+// route asks for JSON as content type
+func jsonStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		if context.Route().jsonRequest && context.ContentType() != ContentType_JSON {
+			context.Response(Response_Unsupported_Media_Type)
+			return
+		}
+		next(context)
+	}
+}
+
+// testing rate limits
+// TODO: need testing
+func rateLimitStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		if context.Route().exeedsRateLimit(context, time.Now()) {
+			context.Response(Response_Too_Many_Requests)
+			return
+		}
+		next(context)
+	}
+}
+
+// credentials sent with request are checked before cookie session is used,
+// request with invalid credentials is handled as anonymous unless route requires authorization,
+// RateLimitAuth of route is checked when request is authenticated, because RateLimitStep
+// runs before credentials are known
+func authenticateStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		route := context.Route()
+		limited := context.isAuth() // cookie session was limited by RateLimitStep
+
+		principal, err := route.authenticate(context)
+		if err != nil {
+			loggy.Warning.Println(context.RemoteAddr(), err)
+			if route.requiresAuth(context) {
+				if _, ok := BearerToken(context); ok {
+					context.AddHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+				} else {
+					route.challenge(context)
+				}
+				context.Response(Response_Unauthorized)
+				return
+			}
+		}
+
+		if principal != nil {
+			context.linkSession(session.NewTransient(context, principal))
+			if !limited && exceedsLimit(route.limitsAuth, context, time.Now()) {
+				context.Response(Response_Too_Many_Requests)
+				return
+			}
+		}
+		next(context)
+	}
+}
+
+// testing if user is authorized
+// route have flag that session must be authorize to access it
+func authStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		route := context.Route()
+
+		// session is not created here, so requests which don't use session don't get one
+		authorized := context.isAuth()
+
+		if (route.authRequest || context.reqAuth()) && !authorized {
+			// if we have set up redirect then on fail we redirect there
+			if route.doredirect {
+				context.Redirect(route.redirect)
+				return
+			}
+			// else just say that we are unauthorized
+			route.challenge(context)
+			context.Response(Response_Unauthorized)
+			return
+		}
+
+		// route requires roles or permissions
+		if !route.allows(context.existingSession()) {
+			if len(route.accessRedirect) > 0 {
+				context.Redirect(route.accessRedirect)
+				return
+			}
+			if !authorized {
+				context.Response(Response_Unauthorized)
+				return
+			}
+			context.Response(Response_Forbidden)
+			return
+		}
+
+		// route can be useful if we add session status in request data
+		// TODO: need some mark to identify core added data, example, $is_auth, $base_url, etc..
+		context.addData("is_auth", authorized)
+
+		next(context)
+	}
+}
+
+func csrfStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		route := context.Route()
+
+		// requests authenticated by Authenticator send credentials themselves, not cookie, and their
+		// sessions are not stored, so they have no CSRF secret
+		authenticated := context.linkedSession() != nil && context.linkedSession().Transient()
+
+		if route.csrfProtect && !authenticated && !checkCSRF(context) {
+			loggy.Warning.Println(context.RemoteAddr(), "CSRF check failed", context.RequestURI())
+			context.Response(Response_Forbidden)
+			return
+		}
+
+		if route.validateCSRFToken {
+			csrf, ok := context.CookieValue("_csrf")
+			if !ok || len(csrf) == 0 || csrf != context.Session().Get("_csrf").String() {
+				context.Response(Response_Forbidden) // TODO: what is best status code for CSRF violation
+				return
+			}
+			context.Session().Delete("_csrf")
+			context.DeleteCookie("_csrf")
+		}
+
+		// TODO: verify that route is good way to emit CSRF tokens
+		if route.emitCSRFToken {
+			// generate csrf token
+			b := make([]byte, 16)
+			rand.Read(b)
+			csrf := Base64Encode(b)
+			context.Session().Set("_csrf", csrf)
+			context.SetCookieValue("_csrf", csrf)
+		}
+
+		next(context)
+	}
+}
+
+func noCacheStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		if context.Route().noCache {
+			// route is for IE to not cache JSON responses!
+			context.AddHeader("If-Modified-Since", "01 Jan 1970 00:00:00 GMT")
+			context.AddHeader("Cache-Control", "no-cache")
+		}
+		next(context)
+	}
+}
+
+// validate all added rules
+func rulesStep(next RouteFunc) RouteFunc {
+	return func(context Context) {
+		for _, rule := range context.Route().rules {
+			if err := rule(context); err != nil {
+				loggy.Warning.Println(context.RemoteAddr(), err)
+				context.WriteJSON(DefaultConfig.err_object_func(Response_Bad_Request, err))
+				context.Response(Response_Bad_Request)
+				return
+			}
+		}
+		next(context)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 )
 
 // Output is interface for route output handler
@@ -18,9 +17,17 @@ type Output interface {
 	// response config
 	SetContentType(string)
 	Response(int)
+	// returns response code that will be written
+	ResponseCode() int
 
 	// some heper functions
 	SetCookieValue(string, string)
+	SetCookie(*CookieOptions)
+	Cookie(name, value string) *CookieOptions
+	DeleteCookie(string)
+	// signed and encrypted cookies, keys are set in configuration
+	SetSignedCookie(name, value string) error
+	SetEncryptedCookie(name, value string) error
 	Redirect(url ...string)
 	AddHeader(string, interface{})
 	Header() http.Header
@@ -29,6 +36,8 @@ type Output interface {
 	Flush()
 
 	noFlush()
+	keepHeaders()
+	discard() bool
 }
 
 type output struct {
@@ -36,12 +45,16 @@ type output struct {
 	buffer       bytes.Buffer
 	responseCode int
 	noflush      bool
+	noBody       bool        // response to HEAD request, only headers are written
+	kept         http.Header // headers restored when output is discarded
+
+	cookieDefaults CookieOptions
+	config         *configStruct // used for cookie keys
 }
 
 func newOutput(response http.ResponseWriter) *output {
 	out := &output{response: response, responseCode: 200}
 	out.SetContentType(MIME_HTML)
-	out.AddHeader("Access-Control-Allow-Origin", "*")
 	return out
 }
 
@@ -53,6 +66,10 @@ func (out *output) Response(code int) {
 	out.responseCode = code
 }
 
+func (out *output) ResponseCode() int {
+	return out.responseCode
+}
+
 func (out *output) Header() http.Header {
 	return out.response.Header()
 }
@@ -78,15 +95,6 @@ func (out *output) SetContentType(mime string) {
 	out.response.Header().Set("Content-Type", mime)
 }
 
-func (out *output) SetCookieValue(name, value string) {
-	cookie := &http.Cookie{}
-	cookie.Expires = time.Now().Add(time.Hour * 24 * 30)
-	cookie.Name = name
-	cookie.Value = value
-	cookie.Path = "/"
-	http.SetCookie(out.response, cookie)
-}
-
 func (out *output) Redirect(url ...string) {
 	if len(url) == 0 {
 		out.response.Header().Set("Location", "/")
@@ -108,6 +116,13 @@ func (out *output) Flush() {
 
 	out.noflush = true
 
+	if out.noBody {
+		if out.buffer.Len() > 0 && out.response.Header().Get("Content-Length") == "" {
+			out.AddHeader("Content-Length", out.buffer.Len())
+		}
+		out.buffer.Reset()
+	}
+
 	out.response.WriteHeader(out.responseCode)
 
 	// write only if there is something to write
@@ -124,3 +139,27 @@ func (out *output) ResponseWriter() http.ResponseWriter {
 func (out *output) noFlush() {
 	out.noflush = true
 }
+
+// keepHeaders marks headers that are kept when output is discarded, for example, security headers
+func (out *output) keepHeaders() {
+	out.kept = out.response.Header().Clone()
+}
+
+// discard drops buffered output and headers set after keepHeaders,
+// returns false if output is already written
+func (out *output) discard() bool {
+	if out.noflush {
+		return false
+	}
+	out.buffer.Reset()
+	out.responseCode = Response_Ok
+
+	header := out.response.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range out.kept {
+		header[name] = values
+	}
+	return true
+}
@@ -1,7 +1,6 @@
 package core
 
 import (
-	"crypto/rand"
 	"fmt"
 	"regexp"
 	"time"
@@ -24,8 +23,12 @@ type RouteFunc func(Context)
 type Route struct {
 	app Router
 
+	index      int    // registration order, routes added first are matched first
+	name       string // name used for building URL
 	patternStr string
-	pattern    *regexp.Regexp
+	pattern    *regexp.Regexp // used only if pattern can't be routed by prefix tree
+	segments   []segment
+	names      []string // parameter names, same order as arguments
 	callback   RouteFunc
 	method     string
 
@@ -38,6 +41,11 @@ type Route struct {
 	redirect    string // if doredirect set then redirects to redirect value
 	doredirect  bool
 
+	// roles and permissions session user must have
+	roles          []string
+	permissions    []string
+	accessRedirect string // if not empty then redirects here when access is denied
+
 	// rate-limits for guest and authorized user
 	limits     *tokenbucket.Buckets // this is rate limit for each IP address
 	limitsAuth *tokenbucket.Buckets
@@ -46,24 +54,71 @@ type Route struct {
 
 	validateCSRFToken bool
 	emitCSRFToken     bool
+	csrfProtect       bool // synchronizer token is checked, see CSRFProtect
+
+	cors          *CORS
+	secureHeaders *SecureHeaders
 
 	needs []string
+
+	middlewares    []Middleware
+	throttle       Middleware // see Throttle
+	authenticators []Authenticator
+	pipeline       []Middleware // built-in steps, if nil DefaultPipeline is used
+
+	group     *Group              // group from which route inherits options
+	options   routeOption         // options set on route itself, these are not overridden by group
+	inherited map[routeOption]int // depth of nearest group from which option is inherited
 }
 
 func newRoute(method, pattern string, callback RouteFunc, router Router) *Route {
-	return &Route{
+	route := &Route{
 		app:        router,
 		patternStr: pattern,
-		pattern:    regexp.MustCompile(pattern),
 		callback:   callback,
 		method:     method,
 	}
+
+	// patterns that can't be stored in prefix tree falls back to regular expressions
+	if segments, ok := parsePattern(pattern); ok {
+		route.segments = segments
+		for _, seg := range segments {
+			if seg.kind != segmentStatic {
+				route.names = append(route.names, seg.value)
+			}
+		}
+	} else {
+		route.pattern = compilePattern(pattern)
+		route.names = route.pattern.SubexpNames()[1:]
+	}
+
+	return route
+}
+
+// Name sets route name, named routes can be used to build URLs with Router.URL
+func (route *Route) Name(name string) *Route {
+	route.name = name
+	return route
+}
+
+// Use adds middlewares to route, route middlewares are executed after application and router middlewares
+func (route *Route) Use(middlewares ...Middleware) *Route {
+	route.middlewares = append(route.middlewares, middlewares...)
+	return route
+}
+
+// Pipeline replaces built-in steps for route, steps can be reordered, left out or replaced,
+// see DefaultPipeline
+func (route *Route) Pipeline(steps ...Middleware) *Route {
+	route.pipeline = steps
+	return route
 }
 
 // ReqAuth marks route so that it can be accessed only by authorized session
 // if session is not authorized request is redirected to route that is passed in argument
 func (route *Route) ReqAuth(args ...string) *Route {
 	route.authRequest = true
+	route.options |= optionAuth
 	if len(args) > 0 {
 		route.redirect = args[0]
 		route.doredirect = true
@@ -71,6 +126,59 @@ func (route *Route) ReqAuth(args ...string) *Route {
 	return route
 }
 
+// NoAuth overrides ReqAuth of group, route can be accessed without authorization,
+// roles and permissions required by group are still checked
+func (route *Route) NoAuth() *Route {
+	route.authRequest = false
+	route.doredirect = false
+	route.options |= optionAuth
+	return route
+}
+
+// ReqRole marks route so that it can be accessed only by authorized session which user has role,
+// if role is missing response is 403, or request is redirected to route that is passed in argument,
+// when called several times all roles are required, roles required by group are required too
+func (route *Route) ReqRole(role string, args ...string) *Route {
+	route.roles = append(route.roles, role)
+	route.options |= optionRole
+	if len(args) > 0 {
+		route.accessRedirect = args[0]
+		route.options |= optionAccessRedirect
+	}
+	return route
+}
+
+// ReqPermission marks route so that it can be accessed only by authorized session which user has permission,
+// works same as ReqRole
+func (route *Route) ReqPermission(permission string, args ...string) *Route {
+	route.permissions = append(route.permissions, permission)
+	route.options |= optionPermission
+	if len(args) > 0 {
+		route.accessRedirect = args[0]
+		route.options |= optionAccessRedirect
+	}
+	return route
+}
+
+// allows checks if session user has all roles and permissions required by route,
+// request without session is allowed only if route requires none
+func (route *Route) allows(s *session.Session) bool {
+	if s == nil {
+		return len(route.roles) == 0 && len(route.permissions) == 0
+	}
+	for _, role := range route.roles {
+		if !s.HasRole(role) {
+			return false
+		}
+	}
+	for _, permission := range route.permissions {
+		if !s.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
+
 // Need functions adds validation for mandatory fields
 func (route *Route) Need(fields ...string) *Route {
 	route.rules = append(route.rules, func(context Context) error {
@@ -93,12 +201,14 @@ func (route *Route) Need(fields ...string) *Route {
 // RateLimitAuth sets routes maximum request rate per time for authorized users
 func (route *Route) RateLimitAuth(rate, per float32) *Route {
 	route.limitsAuth = tokenbucket.NewBuckets(int(rate), rate/per)
+	route.options |= optionRateLimitAuth
 	return route
 }
 
 // RateLimit sets routes maximum request rate per second from specific remote IP
 func (route *Route) RateLimit(rate, per float32) *Route {
 	route.limits = tokenbucket.NewBuckets(int(rate), rate/per)
+	route.options |= optionRateLimit
 	return route
 }
 
@@ -120,6 +230,14 @@ func (route *Route) Match(nameA, nameB string) *Route {
 // JSON adds validation for request content so that only requests with content type json is handled
 func (route *Route) JSON() *Route {
 	route.jsonRequest = true
+	route.options |= optionJSON
+	return route
+}
+
+// NoJSON overrides JSON of group, requests with any content type are handled
+func (route *Route) NoJSON() *Route {
+	route.jsonRequest = false
+	route.options |= optionJSON
 	return route
 }
 
@@ -127,127 +245,113 @@ func (route *Route) JSON() *Route {
 // to client will be sent headers to not cache response
 func (route *Route) NoCache() *Route {
 	route.noCache = true
+	route.options |= optionNoCache
 	return route
 }
 
-// CSRF route option for setting CSRF validations
+// Cache overrides NoCache of group, headers to not cache response are not sent
+func (route *Route) Cache() *Route {
+	route.noCache = false
+	route.options |= optionNoCache
+	return route
+}
+
+// CSRF route option for setting CSRF validations, token is sent in cookie and can be used once,
+// see CSRFProtect for per-session token sent in header or form field
 func (route *Route) CSRF(emit, need bool) *Route {
 	route.emitCSRFToken = emit
 	route.validateCSRFToken = need
+	route.options |= optionCSRF
 	return route
 }
 
 func (route *Route) exeedsRateLimit(context Context, t time.Time) bool {
-
 	// if session is authorized then check auth rate limits
-	if context.Session().IsAuth() {
-		if route.limitsAuth != nil {
-			space, ok := route.limitsAuth.Add(context.RemoteAddr(), t)
-			if !ok {
-				return true
-			}
-
-			context.AddHeader(HeaderXRateLimit, route.limitsAuth.Capacity())
-			context.AddHeader(HeaderXRateLimitRemaining, space)
-		}
+	if context.isAuth() {
+		return exceedsLimit(route.limitsAuth, context, t)
+	}
+	// reached guest rate limit for IP, to many request from this IP
+	return exceedsLimit(route.limits, context, t)
+}
 
+// exceedsLimit takes token from bucket of request IP address and sets rate limit headers,
+// returns true if bucket is empty, nil limits are never exceeded
+func exceedsLimit(limits *tokenbucket.Buckets, context Context, t time.Time) bool {
+	if limits == nil {
 		return false
 	}
 
-	if route.limits != nil {
-		space, ok := route.limits.Add(context.RemoteAddr(), t)
-		if !ok { // reached guest rate limie for IP, to many request from this IP
-			return true
-		}
-
-		context.AddHeader(HeaderXRateLimit, route.limits.Capacity())
-		context.AddHeader(HeaderXRateLimitRemaining, space)
+	space, ok := limits.Add(context.RemoteAddr(), t)
+	if !ok {
+		return true
 	}
 
+	context.AddHeader(HeaderXRateLimit, limits.Capacity())
+	context.AddHeader(HeaderXRateLimitRemaining, space)
 	return false
 }
 
 // route handler method
-func (route *Route) handle(args []t.T, startTime time.Time, context Context) {
+func (route *Route) handle(args []t.T, context Context) {
 	// now defer that at the end we write data
 
 	defer context.Flush()
 
-	// route asks for JSON as content type
-	if route.jsonRequest {
-		if context.ContentType() != ContentType_JSON {
-			context.Response(Response_Unsupported_Media_Type)
-			return
-		}
+	// panic in route function must not kill request without response
+	defer recoverRoute(context)
+
+	if route.secureHeaders != nil {
+		route.secureHeaders.apply(context)
+	}
+	// security headers are sent with error response too, headers set later are discarded on error
+	context.keepHeaders()
+
+	if policy := route.corsPolicy(context); policy != nil {
+		policy.apply(context)
 	}
 
 	// connect our request to session manager
-	context.linkArgs(args)
-	context.linkSession(session.New(context))
+	context.linkArgs(args, route.names)
+	context.linkRoute(route)
 
-	// defer some cleanup when done routing
-	defer context.Session().Unlink()
+	// cookie session is created when it is used first time, see Input.Session
+	// defer some cleanup when done routing, session changes are stored
+	defer saveSession(context)
 
-	// testing rate limits
-	// TODO: need testing
-	if route.exeedsRateLimit(context, startTime) {
-		context.Response(Response_Too_Many_Requests)
-		return
-	}
+	route.chain(context.middlewares())(context)
+}
 
-	// testing if user is authorized
-	// route have flag that session must be authorize to access it
-	if route.authRequest && !context.Session().IsAuth() {
-		// if we have set up redirect then on fail we redirect there
-		if route.doredirect {
-			context.Redirect(route.redirect)
-			return
-		}
-		// else just say that we are unauthorized
-		context.Response(Response_Unauthorized)
+// saveSession stores session changes made while handling request and unlinks it from request
+func saveSession(context Context) {
+	s := context.linkedSession()
+	if s == nil {
 		return
 	}
-
-	if route.validateCSRFToken {
-		csrf, ok := context.CookieValue("_csrf")
-		if !ok || len(csrf) == 0 || csrf != context.Session().Data.Str("_csrf") {
-			context.Response(Response_Forbidden) // TODO: what is best status code for CSRF violation
-			return
-		}
-		delete(context.Session().Data, "_csrf")
-		context.SetCookieValue("_csrf", "")
+	if err := s.Save(); err != nil {
+		loggy.Error.Println("session:", err)
 	}
+	s.Unlink()
+}
 
-	// route can be useful if we add session status in request data
-	// TODO: need some mark to identify core added data, example, $is_auth, $base_url, etc..
-	context.addData("is_auth", context.Session().IsAuth())
-
-	if route.noCache {
-		// route is for IE to not cache JSON responses!
-		context.AddHeader("If-Modified-Since", "01 Jan 1970 00:00:00 GMT")
-		context.AddHeader("Cache-Control", "no-cache")
+// chain wraps route callback in middlewares, outer middlewares (from application) are executed first,
+// then router and route middlewares and at the end built-in pipeline
+func (route *Route) chain(outer []Middleware) RouteFunc {
+	pipeline := route.pipeline
+	if pipeline == nil {
+		pipeline = DefaultPipeline
 	}
 
-	// TODO: verify that route is good way to emit CSRF tokens
-	if route.emitCSRFToken {
-		// generate csrf token
-		b := make([]byte, 16)
-		rand.Read(b)
-		csrf := Base64Encode(b)
-		context.Session().Data["_csrf"] = csrf
-		context.SetCookieValue("_csrf", csrf)
+	fn := route.callback
+	fn = wrap(fn, pipeline)
+	fn = wrap(fn, route.middlewares)
+	if route.throttle != nil {
+		fn = route.throttle(fn)
 	}
-
-	// validate all added rules
-	for _, rule := range route.rules {
-		if err := rule(context); err != nil {
-			loggy.Warning.Println(context.RemoteAddr(), err)
-			context.WriteJSON(DefaultConfig.err_object_func(Response_Bad_Request, err))
-			context.Response(Response_Bad_Request)
-			return
-		}
+	for group := route.group; group != nil; group = group.parent {
+		fn = wrap(fn, group.middlewares)
 	}
-
-	// call route function
-	route.callback(context)
+	if router, ok := route.app.(*defaultRouter); ok {
+		fn = wrap(fn, router.middlewares)
+	}
+	return wrap(fn, outer)
 }
@@ -1,7 +1,16 @@
 package core
 
 import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/jzaikovs/core/session"
 
 	. "github.com/jzaikovs/t"
 )
@@ -34,3 +43,500 @@ func TestMatchNeed(t *testing.T) {
 	assert_s(t, c.post(query, Map{"x": "1", "y": "2"}), `400:{"code":400,"error":"field [x] not match field [y]"}`, "Bad post request")
 	assert_s(t, c.post(query, Map{"a": "1", "b": "1"}), `400:{"code":400,"error":"field [x] required"}`, "Bad post request")
 }
+
+func TestCSRFProtect(t *testing.T) {
+	c := newTestClient()
+
+	APP.Get(`/protect/token`, func(context Context) {
+		context.WriteString(context.CSRFToken())
+	})
+	APP.Post(`/protect/post`, simple_resp("ok")).CSRFProtect()
+	APP.Get(`/protect/login`, func(context Context) {
+		context.Session().Authorize("")
+	})
+
+	post := func(header, token, origin string) string {
+		body := strings.NewReader(`{"_csrf_token":"` + token + `"}`)
+		if len(header) > 0 {
+			body = strings.NewReader(`{}`)
+		}
+		req, _ := http.NewRequest("POST", testServerURL+"/protect/post", body)
+		req.Header.Set("Content-Type", ContentType_JSON)
+		if len(header) > 0 {
+			req.Header.Set(header, token)
+		}
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := c.raw.Do(req)
+		if err != nil {
+			return "ERR"
+		}
+		defer resp.Body.Close()
+		p, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Sprintf("%d:%s", resp.StatusCode, p)
+	}
+
+	token1 := strings.TrimPrefix(c.get("/protect/token"), "200:")
+	token2 := strings.TrimPrefix(c.get("/protect/token"), "200:")
+	assert(t, len(token1) > 0 && token1 != token2, "CSRF tokens are not masked")
+
+	assert_s(t, post("", "", ""), "403:", "Request without token accepted")
+	assert_s(t, post(HeaderXCSRFToken, token1, testServerURL), "200:ok", "Token in header not accepted")
+	assert_s(t, post(HeaderXCSRFToken, token1, testServerURL), "200:ok", "Token not accepted second time")
+	assert_s(t, post("", token2, testServerURL), "200:ok", "Token in form field not accepted")
+	assert_s(t, post(HeaderXCSRFToken, token1, ""), "403:", "Request without Origin and Referer accepted")
+	assert_s(t, post(HeaderXCSRFToken, token1, "http://evil.example"), "403:", "Cross origin request accepted")
+	assert_s(t, newTestClient().post("/protect/post", Map{"_csrf_token": token1}), "403:", "Token of other session accepted")
+
+	// secret is rotated when session gets new ID on login
+	c.get("/protect/login")
+	assert_s(t, post(HeaderXCSRFToken, token1, testServerURL), "403:", "Token issued before login accepted")
+	token3 := strings.TrimPrefix(c.get("/protect/token"), "200:")
+	assert_s(t, post(HeaderXCSRFToken, token3, testServerURL), "200:ok", "Token issued after login not accepted")
+
+	// requests authenticated by authenticator are not checked
+	APP.Post(`/protect/api`, simple_resp("api")).CSRFProtect().
+		Authenticate(APIKeys(map[string]*session.Principal{"key1": {UserID: "service"}}))
+	for key, expected := range map[string]string{"key1": "200:api", "": "403:"} {
+		req, _ := http.NewRequest("POST", testServerURL+"/protect/api", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", ContentType_JSON)
+		if len(key) > 0 {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert_s(t, fmt.Sprintf("%d:%s", resp.StatusCode, p), expected, "Bad CSRF check for API key "+key)
+	}
+}
+
+func TestParam(t *testing.T) {
+	c := newTestClient()
+
+	param := func(context Context) {
+		context.WriteString(context.Param("id").String() + ":" + context.Param("name").String() + ":" + context.Param("none").String())
+	}
+
+	APP.Get(`/param/:id<int>/:name`, param)
+	APP.Get(`/param/{id}`, param)
+	APP.Get(`^/param-re/(?P<name>[a-z]+)/{id}$`, param)
+
+	assert_s(t, c.get("/param/1/x"), "200:1:x:", "Bad named parameters from prefix tree")
+	assert_s(t, c.get("/param/y"), "200:y::", "Bad {name} parameter from prefix tree")
+	assert_s(t, c.get("/param-re/x/1"), "200:1:x:", "Bad named parameters from regular expression")
+}
+
+func TestMiddleware(t *testing.T) {
+	c := newTestClient()
+
+	mark := func(name string) Middleware {
+		return func(next RouteFunc) RouteFunc {
+			return func(context Context) {
+				context.WriteString(name)
+				next(context)
+			}
+		}
+	}
+
+	stop := func(next RouteFunc) RouteFunc {
+		return func(context Context) {
+			context.Response(Response_Forbidden)
+		}
+	}
+
+	sub := New("mw", false)
+	sub.Use(mark("app,"))
+	sub.Router.Use(mark("router,"))
+	sub.Get(`/order`, simple_resp("done")).Use(mark("route,"))
+	sub.Get(`/stop`, simple_resp("done")).Use(stop)
+	sub.Get(`/pipeline`, simple_resp("done")).Pipeline(mark("step,"), JSONStep).JSON()
+	APP.Sub("mw", sub)
+
+	assert_s(t, c.get("/mw/order"), "200:app,router,route,done", "Bad middleware order")
+	assert_s(t, c.get("/mw/stop"), "403:app,router,", "Middleware did not stop request")
+	assert_s(t, c.get("/mw/pipeline"), "415:app,router,step,", "Bad custom pipeline")
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	sub := New("hmw", false)
+	sub.Use(func(next RouteFunc) RouteFunc {
+		return func(context Context) {
+			context.AddHeader("X-Mark", "app")
+			next(context)
+		}
+	})
+	sub.Handle(`/handler`, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("handler"))
+	}))
+	sub.Post(`/json`, simple_resp("json")).JSON()
+	APP.Sub("hmw", sub)
+
+	resp, err := http.Get(testServerURL + "/hmw/handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, resp.Header.Get("X-Mark") == "app" && _read_cmp(resp.Body, "handler"), "Handler not called through middlewares")
+
+	// rejected request does not get session
+	resp, err = http.Post(testServerURL+"/hmw/json", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == Response_Unsupported_Media_Type, "Request without JSON accepted")
+	assert(t, len(resp.Header["Set-Cookie"]) == 0, "Session created for rejected request")
+}
+
+func TestGroup(t *testing.T) {
+	c := newTestClient()
+
+	APP.Group("/group/v1", func(api Router) {
+		api.Get(`/public`, simple_resp("public")).NoAuth()
+		api.Get(`/private`, simple_resp("private"))
+		api.Get(`^/regexp/(\d+)$`, func(context Context) {
+			context.WriteString(context.Args(0).String())
+		}).ReqAuth()
+
+		api.Group("/nested", func(nested Router) {
+			nested.Get(`/open`, simple_resp("open"))
+			nested.Get(`/cached`, simple_resp("cached")).Cache()
+		}).NoCache().Use(func(next RouteFunc) RouteFunc {
+			return func(context Context) {
+				context.WriteString("nested,")
+				next(context)
+			}
+		})
+	}).ReqAuth().RateLimit(10, 1)
+
+	APP.Group("/group/json", func(api Router) {
+		api.Get(`/data`, simple_resp("data"))
+		api.Get(`/form`, simple_resp("form")).NoJSON()
+	}).JSON()
+
+	// route options overrides group options
+	assert_s(t, c.get("/group/v1/public"), "200:public", "Route option not applied")
+	assert_s(t, c.get("/group/v1/private"), "401:", "Group option not applied")
+	assert_s(t, c.get("/group/json/data"), "415:", "Group option not applied")
+	assert_s(t, c.get("/group/json/form"), "200:form", "Route option not applied")
+	assert_s(t, c.get("/group/v1/nested/open"), "401:nested,", "Nested group did not inherit option")
+
+	APP.Post(`/group/login`, func(context Context) {
+		context.Session().Authorize("")
+	})
+	c.post("/group/login", Map{})
+
+	assert_s(t, c.get("/group/v1/private"), "200:private", "Group route not working")
+	assert_s(t, c.get("/group/v1/regexp/5"), "200:5", "Group regexp route not working")
+	assert_s(t, c.get("/group/v1/nested/open"), "200:nested,open", "Nested group middleware not working")
+
+	// options set on group after its routes handled requests are applied too,
+	// same as to routes added after group options are set
+	late := APP.Group("/group/late", func(api Router) {
+		api.Get(`/before`, simple_resp("before"))
+	})
+	assert_s(t, newTestClient().get("/group/late/before"), "200:before", "Group route not working")
+	late.JSON()
+	late.Get(`/after`, simple_resp("after"))
+	assert_s(t, newTestClient().get("/group/late/before"), "415:", "Group option set after request not applied")
+	assert_s(t, newTestClient().get("/group/late/after"), "415:", "Group option not applied to route added later")
+
+	for query, cache := range map[string]string{"/group/v1/nested/open": "no-cache", "/group/v1/nested/cached": ""} {
+		resp, err := c.raw.Get(testServerURL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert_s(t, resp.Header.Get("Cache-Control"), cache, "Bad NoCache option for "+query)
+	}
+}
+
+// nearest group option has priority regardless of order in which options are set
+func TestGroupInheritance(t *testing.T) {
+	router := NewRouter().(*defaultRouter)
+
+	var route *Route
+	parent := router.Group("/parent", nil).ReqRole("admin")
+	nested := parent.Group("/nested", func(nested Router) {
+		route = nested.Get(`/route`, simple_resp(""))
+	})
+	nested.CSRF(true, false).ReqRole("editor", "/denied")
+	parent.CSRF(false, true).ReqRole("owner", "/parent-denied")
+
+	assert(t, route.emitCSRFToken && !route.validateCSRFToken, "Parent group option overrides nested group option")
+	assert_s(t, strings.Join(route.roles, ","), "admin,editor,owner", "Bad roles inherited")
+	assert_s(t, route.accessRedirect, "/denied", "Parent group redirect overrides nested group redirect")
+
+	// route options are not overridden by group
+	route.CSRF(false, false)
+	parent.CSRF(true, true)
+	nested.CSRF(true, true)
+	assert(t, !route.emitCSRFToken && !route.validateCSRFToken, "Group option overrides route option")
+}
+
+func TestRoles(t *testing.T) {
+	c := newTestClient()
+
+	APP.Group("/roles", func(admin Router) {
+		admin.Get(`/admin`, func(context Context) {
+			context.WriteString(context.Principal().UserID)
+		})
+		admin.Get(`/orders`, simple_resp("orders")).ReqPermission("orders:write")
+		admin.Get(`/moved`, simple_resp("moved")).ReqPermission("orders:write", "/roles/denied")
+		admin.Get(`/editor`, simple_resp("editor")).ReqRole("editor")
+	}).ReqRole("admin")
+
+	APP.Get(`/roles/denied`, simple_resp("denied"))
+	APP.Post(`/roles/login`, func(context Context) {
+		context.Session().Login("u1", "", strings.Split(context.Data().Str("roles"), ","), []string{"orders:read"})
+	})
+
+	assert_s(t, c.get("/roles/admin"), "401:", "Role allowed unauthorized session")
+
+	c.post("/roles/login", Map{"roles": "admin"})
+
+	assert_s(t, c.get("/roles/admin"), "200:u1", "Role not allowed")
+	assert_s(t, c.get("/roles/orders"), "403:", "Missing permission allowed")
+	assert_s(t, c.get("/roles/moved"), "200:denied", "Missing permission not redirected")
+	assert_s(t, c.get("/roles/editor"), "403:", "Missing route role allowed")
+
+	// route roles are required in addition to group roles
+	editor := newTestClient()
+	editor.post("/roles/login", Map{"roles": "editor"})
+	assert_s(t, editor.get("/roles/editor"), "403:", "Missing group role allowed")
+
+	both := newTestClient()
+	both.post("/roles/login", Map{"roles": "admin,editor"})
+	assert_s(t, both.get("/roles/editor"), "200:editor", "Group and route roles not allowed")
+}
+
+func TestFlash(t *testing.T) {
+	c := newTestClient()
+
+	tmpl := template.Must(template.New("flash").Parse(`{{range .flashes.msg}}{{.}};{{end}}`))
+
+	APP.Post(`/flash/save`, func(context Context) {
+		context.Session().Flash("msg", "saved!")
+		context.Session().Flash("msg", "again!")
+	})
+	APP.Get(`/flash/show`, func(context Context) {
+		context.Render(tmpl, nil)
+	})
+
+	c.post("/flash/save", Map{})
+	assert_s(t, c.get("/flash/show"), "200:saved!;again!;", "Flash messages not rendered")
+	assert_s(t, c.get("/flash/show"), "200:", "Flash messages not removed")
+}
+
+// run with -race, parallel requests with same session ID
+func TestSessionRace(t *testing.T) {
+	c := newTestClient()
+
+	APP.Get(`/race/inc`, func(context Context) {
+		context.Session().Update(func(data Map) {
+			count, _ := data["count"].(int)
+			data["count"] = count + 1
+		})
+		context.Session().Set("last", context.RemoteAddr())
+		context.Session().Flash("msg", "inc")
+	})
+	APP.Get(`/race/count`, func(context Context) {
+		context.WriteString(context.Session().Get("count").String())
+	})
+
+	c.get("/race/count")
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.get("/race/inc")
+		}()
+	}
+	wg.Wait()
+
+	assert_s(t, c.get("/race/count"), fmt.Sprintf("200:%d", n), "Concurrent session updates lost")
+}
+
+func TestMethods(t *testing.T) {
+	APP.Get(`/methods`, simple_resp("get"))
+	APP.Patch(`/methods`, simple_resp("patch"))
+	APP.Method("purge", `/methods`, simple_resp("purge"))
+	APP.Any(`/methods/any`, func(context Context) {
+		context.WriteString(context.Method())
+	})
+
+	do := func(method, query string) *http.Response {
+		req, _ := http.NewRequest(method, testServerURL+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	assert(t, _read_cmp(do("PATCH", "/methods").Body, "patch"), "PATCH route not working")
+	assert(t, _read_cmp(do("PURGE", "/methods").Body, "purge"), "Custom method route not working")
+	assert(t, _read_cmp(do("DELETE", "/methods/any").Body, "DELETE"), "Any route not working")
+
+	resp := do("HEAD", "/methods")
+	assert(t, resp.StatusCode == Response_Ok && resp.ContentLength == 3, "HEAD not answered by GET route")
+
+	resp = do("OPTIONS", "/methods")
+	assert_s(t, resp.Header.Get("Allow"), "GET, HEAD, OPTIONS, PATCH, PURGE", "Bad Allow header")
+	assert(t, resp.StatusCode == Response_No_Content, "OPTIONS not answered")
+}
+
+func TestRecover(t *testing.T) {
+	c := newTestClient()
+
+	APP.Get(`/panic`, func(context Context) {
+		context.WriteString("lost")
+		context.SetCookieValue("lost", "1")
+		context.Redirect("/lost")
+		context.Args(5)
+	})
+
+	APP.Get(`/error`, ErrFunc(func(context Context) error {
+		context.WriteString("lost")
+		return fmt.Errorf("failed")
+	}))
+
+	assert_s(t, c.get("/panic"), `500:{"code":500,"error":"internal server error"}`, "Panic not recovered")
+	assert_s(t, c.get("/error"), `500:{"code":500,"error":"internal server error"}`, "Error not handled")
+	assert(t, c.cookie("lost") == "", "Headers of failed route not discarded")
+}
+
+func TestThrottle(t *testing.T) {
+	c := newTestClient()
+
+	// clock is moved by test, so lockout expires without waiting
+	var clock sync.Mutex
+	now := time.Now()
+	throttle := NewThrottle(2, 100, time.Hour)
+	throttle.now = func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+		return now
+	}
+
+	APP.Post(`/throttle/login`, func(context Context) {
+		if context.Data().Str("password") != "ok" {
+			context.Response(Response_Unauthorized)
+		}
+	}).Throttle(throttle, "user")
+
+	for i := 0; i < 3; i++ {
+		assert_s(t, c.post("/throttle/login", Map{"user": "bob", "password": "x"}), "401:", "Failed attempt not rejected")
+	}
+
+	resp, err := http.Post(testServerURL+"/throttle/login", ContentType_JSON, strings.NewReader(`{"user":"bob","password":"ok"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == 429 && resp.Header.Get("Retry-After") == "1", "Locked out user not throttled")
+
+	assert_s(t, c.post("/throttle/login", Map{"user": "alice", "password": "ok"}), "200:", "Other user throttled")
+
+	clock.Lock()
+	now = now.Add(time.Second)
+	clock.Unlock()
+	assert_s(t, c.post("/throttle/login", Map{"user": "bob", "password": "ok"}), "200:", "Lockout did not expire")
+}
+
+func TestThrottleEviction(t *testing.T) {
+	throttle := NewThrottle(2, 100, time.Minute)
+	throttle.MaxKeys = 50
+	now := time.Now()
+
+	throttle.Fail("bob", "127.0.0.1", now)
+	buckets := throttle.perID
+	throttle.Fail("bob", "127.0.0.1", now)
+	throttle.Fail("bob", "127.0.0.1", now)
+	_, ok := throttle.Allowed("bob", "127.0.0.1", now)
+	assert(t, !ok && throttle.perID == buckets, "Failed attempts not counted")
+
+	// buckets are rebuilt after period, lockouts are kept
+	throttle.Fail("alice", "127.0.0.1", now.Add(2*time.Minute))
+	assert(t, throttle.perID != buckets, "Buckets not rebuilt after period")
+	assert(t, len(throttle.lockouts) > 0, "Lockouts forgotten")
+
+	// and when too many keys are counted
+	buckets = throttle.perID
+	for i := 0; i < 50; i++ {
+		throttle.Fail(fmt.Sprint("user", i), "127.0.0.1", now.Add(2*time.Minute))
+	}
+	assert(t, throttle.perID != buckets, "Buckets not rebuilt after MaxKeys attempts")
+}
+
+func TestGroupThrottle(t *testing.T) {
+	c := newTestClient()
+	throttle := NewThrottle(1, 100, time.Hour)
+
+	APP.Group("/account", func(api Router) {
+		api.Post(`/login`, func(context Context) { context.Response(Response_Unauthorized) })
+		api.Post(`/reset`, func(context Context) { context.Response(Response_Unauthorized) })
+	}).Throttle(throttle, "user")
+
+	assert_s(t, c.post("/account/login", Map{"user": "eve"}), "401:", "Failed attempt not rejected")
+	assert_s(t, c.post("/account/reset", Map{"user": "eve"}), "401:", "Failed attempt not rejected")
+	assert_s(t, c.post("/account/reset", Map{"user": "eve"}), "429:", "Attempts of group routes not counted together")
+}
+
+func TestCORS(t *testing.T) {
+	policy := &CORS{
+		AllowedOrigins:   []string{"https://*.example.com", "http://app.test"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Total"},
+		MaxAge:           600,
+	}
+
+	APP.Group("/cors", func(api Router) {
+		api.Get(`/items`, simple_resp("items"))
+		api.Post(`/items`, simple_resp("created"))
+	}).CORS(policy)
+	APP.Get(`/nocors`, simple_resp("nocors"))
+
+	do := func(method, query, origin string, headers ...string) *http.Response {
+		req, _ := http.NewRequest(method, testServerURL+query, nil)
+		req.Header.Set("Origin", origin)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("OPTIONS", "/cors/items", "https://api.example.com", "Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "Content-Type")
+	assert(t, resp.StatusCode == 204, "Preflight not answered")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Origin"), "https://api.example.com", "Preflight origin not allowed")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Methods"), "GET, HEAD, OPTIONS, POST", "Preflight methods not from route table")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Headers"), "Accept, Accept-Language, Content-Language, Content-Type", "Preflight headers not safelisted")
+	assert_s(t, resp.Header.Get("Access-Control-Max-Age"), "600", "Preflight max-age not set")
+
+	resp = do("GET", "/cors/items", "http://app.test")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Origin"), "http://app.test", "Origin not allowed")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Credentials"), "true", "Credentials not allowed")
+	assert_s(t, resp.Header.Get("Access-Control-Expose-Headers"), "X-Total", "Headers not exposed")
+
+	resp = do("GET", "/cors/items", "https://example.com.evil.test")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Origin"), "", "Other origin allowed")
+
+	resp = do("GET", "/nocors", "http://app.test")
+	assert_s(t, resp.Header.Get("Access-Control-Allow-Origin"), "", "Origin allowed without policy")
+
+	defer func() {
+		assert(t, recover() != nil, "Credentials allowed for any origin")
+	}()
+	APP.Get(`/anycors`, simple_resp("any")).CORS(&CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
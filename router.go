@@ -2,7 +2,8 @@ package core
 
 import (
 	"net/http"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/jzaikovs/core/loggy"
 	"github.com/jzaikovs/t"
@@ -16,36 +17,139 @@ type Router interface {
 	Post(string, RouteFunc) *Route
 	Put(string, RouteFunc) *Route
 	Delete(string, RouteFunc) *Route
+	Patch(string, RouteFunc) *Route
+	Head(string, RouteFunc) *Route
+	Options(string, RouteFunc) *Route
+	// adds route for all methods
+	Any(string, RouteFunc) *Route
+	// adds route for specific method
+	Method(method, pattern string, callback RouteFunc) *Route
 	// main routing function
-	Route(context Context) bool
+	Route(context Context) RouteStatus
 	Handle(pattern string, handler http.Handler)
+	// adds middlewares for all routes in router
+	Use(...Middleware)
+	// creates sub-router with shared prefix, middlewares and route options
+	Group(prefix string, fn func(Router)) *Group
+	// builds URL path for named route
+	URL(name string, params ...interface{}) (string, error)
 }
 
+// RouteStatus is result of routing request
+type RouteStatus int
+
+const (
+	// RouteNotFound means that there is no route for request path
+	RouteNotFound RouteStatus = iota
+	// RouteFound means that request was handled by route
+	RouteFound
+	// RouteMethodNotAllowed means that there are routes for request path, but not for request method,
+	// router sets Allow header with methods that can be used
+	RouteMethodNotAllowed
+)
+
+// handlerMethod is method used for routes added by Handle, they match any request method
+const handlerMethod = "?"
+
+// anyMethods are methods for which route is added using Any
+var anyMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
 type defaultRouter struct {
-	routes []*Route
+	routes  []*Route            // all routes in order they were added
+	trees   map[string]*node    // prefix tree for each method
+	regexps map[string][]*Route // routes with regular expression patterns for each method
+
+	middlewares []Middleware
 }
 
 // NewRouter is constructor for creating router instance for default core router
 func NewRouter() Router {
-	return &defaultRouter{routes: make([]*Route, 0)}
+	return &defaultRouter{
+		routes:  make([]*Route, 0),
+		trees:   make(map[string]*node),
+		regexps: make(map[string][]*Route),
+	}
 }
 
 // Route if main method for dispatching routes
-// returns true if found route
-func (router *defaultRouter) Route(context Context) bool {
+// returns RouteFound if found route
+func (router *defaultRouter) Route(context Context) RouteStatus {
 
 	//loggy.Log("ROUTE", context.RemoteAddr(), context.Method(), context.RequestURI())
 
-	startTime := time.Now()
+	method, uri := context.Method(), context.RequestURI()
+
+	// CORS preflight is answered using policy of route that will handle actual request
+	if isPreflight(context) {
+		requested := context.HeaderValue("Access-Control-Request-Method")
+		r, _ := router.match(requested, uri)
+		if r == nil && requested == "HEAD" {
+			r, _ = router.match("GET", uri)
+		}
+		if r != nil && !r.handler {
+			if policy := r.corsPolicy(context); policy != nil {
+				policy.preflight(context, router.allowed(uri))
+				context.Flush()
+				return RouteFound
+			}
+		}
+	}
+
+	r, args := router.match(method, uri)
+	if r == nil && method == "HEAD" {
+		// HEAD is answered by GET route, output will not write body
+		r, args = router.match("GET", uri)
+	}
+	// handlers match any method, route added first wins
+	if h, hargs := router.match(handlerMethod, uri); h != nil && (r == nil || h.index < r.index) {
+		r, args = h, hargs
+	}
+
+	if r == nil {
+		allowed := router.allowed(uri)
+		if len(allowed) == 0 {
+			return RouteNotFound
+		}
+
+		context.AddHeader("Allow", strings.Join(allowed, ", "))
+
+		if method == "OPTIONS" {
+			// OPTIONS is answered automatically
+			context.Response(Response_No_Content)
+			context.Flush()
+			return RouteFound
+		}
+
+		return RouteMethodNotAllowed
+	}
+
+	// so we found our request
+	r.handle(args, context)
+	return RouteFound
+}
+
+// match finds route for method and request URI, route from prefix tree is used
+// unless regular expression route added before it matches too,
+// so routes are matched in order they were added, same as without prefix tree
+func (router *defaultRouter) match(method, uri string) (*Route, []t.T) {
+	var found *Route
+	var foundArgs []t.T
 
-	// TODO: router can be more optimized, for example dividing in buckets for each method
-	// TODO: try use trie (aka prefix-tree) as routing method
-	for _, r := range router.routes {
-		if !r.handler && context.Method() != r.method {
-			continue // skip routes with different method
+	if tree, ok := router.trees[method]; ok {
+		path := uri
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
 		}
 
-		matches := r.pattern.FindStringSubmatch(context.RequestURI())
+		found, foundArgs = tree.lookup(splitPath(path), make([]t.T, 0, 4))
+	}
+
+	for _, r := range router.regexps[method] {
+		if found != nil && r.index > found.index {
+			break
+		}
+
+		matches := r.pattern.FindStringSubmatch(uri)
 
 		//loggy.Trace.Println(matches)
 
@@ -53,11 +157,6 @@ func (router *defaultRouter) Route(context Context) bool {
 			continue // no match, go to next
 		}
 
-		if r.handler {
-			r.callback(context)
-			return true
-		}
-
 		// create arguments from groups in route pattern
 		// each group is next argument in arguments
 		matches = matches[1:]
@@ -66,21 +165,94 @@ func (router *defaultRouter) Route(context Context) bool {
 			args[i] = t.T{Value: match}
 		}
 
-		// so we found our request
-		r.handle(args, startTime, context)
-		return true
+		return r, args
 	}
 
+	return found, foundArgs
+}
+
+// allowed returns sorted list of methods which have route for request URI
+func (router *defaultRouter) allowed(uri string) []string {
+	set := make(map[string]bool)
+	for method := range router.trees {
+		set[method] = true
+	}
+	for method := range router.regexps {
+		set[method] = true
+	}
+	delete(set, handlerMethod)
+
+	allowed := make([]string, 0, len(set)+2)
+	for method := range set {
+		if r, _ := router.match(method, uri); r != nil {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return allowed
+	}
+
+	// HEAD is answered by GET route and OPTIONS is answered automatically
+	if contains(allowed, "GET") && !contains(allowed, "HEAD") {
+		allowed = append(allowed, "HEAD")
+	}
+	if !contains(allowed, "OPTIONS") {
+		allowed = append(allowed, "OPTIONS")
+	}
+
+	sort.Strings(allowed)
+	return allowed
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
 	return false
 }
 
 func (router *defaultRouter) addRoute(method, pattern string, callback RouteFunc) *Route {
 	loggy.Info.Println(method, pattern)
 	r := newRoute(method, pattern, callback, router)
+	r.index = len(router.routes)
 	router.routes = append(router.routes, r)
+	router.insert(method, r)
 	return r
 }
 
+// insert adds route in prefix tree or regular expression list for method.
+// Anchored regular expression added for path that tree already has falls back to
+// regular expression list, so route added first wins, same as before prefix tree,
+// for parameter patterns it panics, same as for invalid regular expression
+func (router *defaultRouter) insert(method string, r *Route) {
+	if r.pattern != nil {
+		router.regexps[method] = append(router.regexps[method], r)
+		return
+	}
+
+	tree, ok := router.trees[method]
+	if !ok {
+		tree = newNode(segment{})
+		router.trees[method] = tree
+	}
+
+	if err := tree.insert(r.segments, r); err != nil {
+		if len(r.names) > 0 {
+			panic("core: " + err.Error())
+		}
+		r.pattern = compilePattern(r.patternStr)
+		router.regexps[method] = append(router.regexps[method], r)
+	}
+}
+
+// Use adds middlewares for all routes in router, middlewares are executed in order they are added
+func (router *defaultRouter) Use(middlewares ...Middleware) {
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
 // Get adds router handler for GET request
 func (router *defaultRouter) Get(pattern string, callback RouteFunc) *Route {
 	return router.addRoute("GET", pattern, callback)
@@ -101,12 +273,53 @@ func (router *defaultRouter) Delete(pattern string, callback RouteFunc) *Route {
 	return router.addRoute("DELETE", pattern, callback)
 }
 
-// Handle implemted to support 3rd party packages that uses http.Handler
+// Patch adds router for PATCH request
+func (router *defaultRouter) Patch(pattern string, callback RouteFunc) *Route {
+	return router.addRoute("PATCH", pattern, callback)
+}
+
+// Head adds router for HEAD request, without HEAD route requests are answered by GET route
+func (router *defaultRouter) Head(pattern string, callback RouteFunc) *Route {
+	return router.addRoute("HEAD", pattern, callback)
+}
+
+// Options adds router for OPTIONS request, without OPTIONS route requests are answered
+// automatically with Allow header
+func (router *defaultRouter) Options(pattern string, callback RouteFunc) *Route {
+	return router.addRoute("OPTIONS", pattern, callback)
+}
+
+// Method adds router for request with specific method
+func (router *defaultRouter) Method(method, pattern string, callback RouteFunc) *Route {
+	return router.addRoute(strings.ToUpper(method), pattern, callback)
+}
+
+// Any adds single route for all methods
+func (router *defaultRouter) Any(pattern string, callback RouteFunc) *Route {
+	loggy.Info.Println("ANY", pattern)
+	r := newRoute("*", pattern, callback, router)
+	r.index = len(router.routes)
+	router.routes = append(router.routes, r)
+	for _, method := range anyMethods {
+		router.insert(method, r)
+	}
+	return r
+}
+
+// Handle implemted to support 3rd party packages that uses http.Handler,
+// handler is called after application, router and group middlewares,
+// built-in steps are not executed, because handler routes have no options
 func (router *defaultRouter) Handle(pattern string, handler http.Handler) {
-	r := router.addRoute("?", pattern, func(context Context) {
+	router.handle(pattern, handler)
+}
+
+func (router *defaultRouter) handle(pattern string, handler http.Handler) *Route {
+	r := router.addRoute(handlerMethod, pattern, func(context Context) {
 		context.noFlush()
 		handler.ServeHTTP(context.ResponseWriter(), context.Request())
 	})
 	// mark router as handler
 	r.handler = true
+	r.pipeline = []Middleware{}
+	return r
 }
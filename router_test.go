@@ -0,0 +1,134 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestRouterTree(t *testing.T) {
+	router := NewRouter().(*defaultRouter)
+
+	router.Get(`^/users$`, simple_resp("list"))
+	router.Get(`^/users/me$`, simple_resp("me"))
+	router.Get(`/users/:id<int>`, simple_resp("id"))
+	router.Get(`/users/:name`, simple_resp("name"))
+	router.Get(`/users/:id/posts/:post`, simple_resp("post"))
+	router.Get(`/static/*path`, simple_resp("static"))
+	router.Get(`^/old/(\d+)/([^/]+)$`, simple_resp("regexp"))
+	router.Post(`^/users$`, simple_resp("create"))
+
+	for _, test := range []struct {
+		method, uri, pattern string
+		args                 []string
+	}{
+		{"GET", "/users", `^/users$`, nil},
+		{"GET", "/users?page=2", `^/users$`, nil},
+		{"GET", "/users/me", `^/users/me$`, nil},
+		{"GET", "/users/42", `/users/:id<int>`, []string{"42"}},
+		{"GET", "/users/john", `/users/:name`, []string{"john"}},
+		{"GET", "/users/42/posts/7", `/users/:id/posts/:post`, []string{"42", "7"}},
+		{"GET", "/static/css/main.css", `/static/*path`, []string{"css/main.css"}},
+		{"GET", "/users/a%20b", `/users/:name`, []string{"a b"}},
+		{"GET", "/static/my%20css/main.css", `/static/*path`, []string{"my css/main.css"}},
+		{"GET", "/users/%zz", "", nil},
+		{"GET", "/old/1/test", `^/old/(\d+)/([^/]+)$`, []string{"1", "test"}},
+		{"POST", "/users", `^/users$`, nil},
+		{"GET", "/users/", "", nil},
+		{"PUT", "/users", "", nil},
+		{"GET", "/nothing", "", nil},
+	} {
+		r, args := router.match(test.method, test.uri)
+		if r == nil {
+			assert(t, test.pattern == "", "No route for "+test.method+" "+test.uri)
+			continue
+		}
+
+		assert_s(t, r.patternStr, test.pattern, "Bad route for "+test.method+" "+test.uri)
+		assert(t, len(args) == len(test.args), "Bad argument count for "+test.uri)
+
+		for i := 0; i < len(args) && i < len(test.args); i++ {
+			assert_s(t, args[i].String(), test.args[i], "Bad argument for "+test.uri)
+		}
+	}
+}
+
+func TestRouterOrder(t *testing.T) {
+	router := NewRouter().(*defaultRouter)
+
+	router.Get(`/legacy`, simple_resp("legacy"))
+	router.Get(`^/items/(\d+)$`, simple_resp("regexp"))
+	router.Get(`/items/:id`, simple_resp("param"))
+	router.Get(`/posts/:id`, simple_resp("param"))
+	router.Get(`^/posts/(\d+)$`, simple_resp("regexp"))
+	router.Get(`^/about$`, simple_resp("first"))
+	router.Get(`^/about$`, simple_resp("second"))
+	router.Get(`^/file.json$`, simple_resp("file"))
+
+	for _, test := range []struct {
+		uri, pattern string
+	}{
+		// unanchored patterns match anywhere in request URI, same as before prefix tree
+		{"/legacy", `/legacy`},
+		{"/legacy/1", `/legacy`},
+		{"/api/legacy?x=1", `/legacy`},
+		// route added first wins
+		{"/items/1", `^/items/(\d+)$`},
+		{"/items/x", `/items/:id`},
+		{"/posts/1", `/posts/:id`},
+		{"/file.json", `^/file.json$`},
+		// dot is regular expression, not literal
+		{"/fileXjson", `^/file.json$`},
+	} {
+		r, _ := router.match("GET", test.uri)
+		if r == nil {
+			t.Error("No route for " + test.uri)
+			continue
+		}
+		assert_s(t, r.patternStr, test.pattern, "Bad route for "+test.uri)
+	}
+
+	// same anchored pattern added twice, first one wins
+	r, _ := router.match("GET", "/about")
+	assert(t, r != nil && r == router.routes[len(router.routes)-3], "Duplicate pattern did not match first route")
+
+	defer func() {
+		assert(t, recover() != nil, "Conflicting route added without panic")
+	}()
+	router.Get(`/items/:id`, simple_resp("again"))
+}
+
+func TestURL(t *testing.T) {
+	app := New("test", false)
+	app.Config = newConfigStruct()
+	app.Config.BaseURL = "http://example.com/"
+
+	app.Get(`/users/:id<int>`, simple_resp("")).Name("user.show")
+	app.Get(`^/files/(?P<file>[^/]+)/{rev}$`, simple_resp("")).Name("file.show")
+
+	sub := New("admin", false)
+	sub.Get(`/static/*path`, simple_resp("")).Name("admin.static")
+	app.Sub("admin", sub)
+
+	for _, test := range []struct {
+		name   string
+		params []interface{}
+		url    string
+	}{
+		{"user.show", []interface{}{"id", 42}, "http://example.com/users/42"},
+		{"user.show", []interface{}{"id", 42, "tab", "posts"}, "http://example.com/users/42?tab=posts"},
+		{"file.show", []interface{}{"file", "a b", "rev", 2}, "http://example.com/files/a%20b/2"},
+		{"admin.static", []interface{}{"path", "css/main.css"}, "http://example.com/admin/static/css/main.css"},
+		{"user.show", []interface{}{"id", "x"}, ""},
+		{"user.show", nil, ""},
+		{"unknown", nil, ""},
+	} {
+		u, err := app.URL(test.name, test.params...)
+		assert_s(t, u, test.url, "Bad URL for "+test.name)
+		assert(t, (err == nil) == (test.url != ""), "Bad error for "+test.name)
+	}
+
+	// parameters survive round trip through URL and router
+	app.Get(`/people/:name`, simple_resp("")).Name("person.show")
+	path, _ := app.Router.URL("person.show", "name", "a b/c")
+	_, args := app.Router.(*defaultRouter).match("GET", path)
+	assert(t, len(args) == 1 && args[0].String() == "a b/c", "Parameter not unescaped: "+path)
+}
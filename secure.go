@@ -0,0 +1,135 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jzaikovs/core/loggy"
+)
+
+// CSPNonceKey is key of template data which holds CSP nonce of request
+const CSPNonceKey = "csp_nonce"
+
+// SecureHeaders is policy of security headers added to responses, empty fields are not sent,
+// policy is set in configuration and can be replaced for route with Route.SecureHeaders
+type SecureHeaders struct {
+	// CSP is Content-Security-Policy, "{nonce}" is replaced with per-request nonce,
+	// for example, "script-src 'self' 'nonce-{nonce}'"
+	CSP string `json:"csp"`
+	// CSPReportOnly sends policy in Content-Security-Policy-Report-Only header
+	CSPReportOnly bool `json:"csp_report_only"`
+	// ReportURI is path where violation reports are received and logged, it is added to CSP
+	ReportURI string `json:"report_uri"`
+
+	FrameOptions       string `json:"frame_options"`        // X-Frame-Options, DENY or SAMEORIGIN
+	ContentTypeOptions bool   `json:"content_type_options"` // X-Content-Type-Options: nosniff
+	ReferrerPolicy     string `json:"referrer_policy"`
+	PermissionsPolicy  string `json:"permissions_policy"`
+
+	// Strict-Transport-Security is sent only with HTTPS responses, requests forwarded
+	// by trusted_proxies with "X-Forwarded-Proto: https" are HTTPS too
+	HSTSMaxAge            int  `json:"hsts_max_age"`
+	HSTSIncludeSubdomains bool `json:"hsts_include_subdomains"`
+	HSTSPreload           bool `json:"hsts_preload"`
+}
+
+// SecureHeaders sets security headers policy for route, policy replaces application policy
+func (route *Route) SecureHeaders(policy *SecureHeaders) *Route {
+	route.secureHeaders = policy
+	route.options |= optionSecureHeaders
+	return route
+}
+
+// SecureHeaders sets security headers policy for all routes in group
+func (group *Group) SecureHeaders(policy *SecureHeaders) *Group {
+	group.set(optionSecureHeaders, func(r *Route) { r.SecureHeaders(policy) })
+	return group
+}
+
+// CSPNonce returns random nonce of request used in CSP, same nonce is returned for whole request
+func (in *defaultInput) CSPNonce() string {
+	if len(in.nonce) == 0 {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic("core: can't generate CSP nonce: " + err.Error())
+		}
+		in.nonce = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return in.nonce
+}
+
+// apply sets headers of policy, headers not set in policy are removed,
+// so route policy replaces application policy
+func (policy *SecureHeaders) apply(context Context) {
+	header := context.Header()
+
+	set := func(name, value string) {
+		if len(value) > 0 {
+			header.Set(name, value)
+		} else {
+			header.Del(name)
+		}
+	}
+
+	csp := policy.CSP
+	if strings.Contains(csp, "{nonce}") {
+		csp = strings.Replace(csp, "{nonce}", context.CSPNonce(), -1)
+	}
+	if len(csp) > 0 && len(policy.ReportURI) > 0 && !strings.Contains(csp, "report-uri") {
+		csp += "; report-uri " + policy.ReportURI
+	}
+	header.Del("Content-Security-Policy")
+	header.Del("Content-Security-Policy-Report-Only")
+	if policy.CSPReportOnly {
+		set("Content-Security-Policy-Report-Only", csp)
+	} else {
+		set("Content-Security-Policy", csp)
+	}
+
+	set("X-Frame-Options", policy.FrameOptions)
+	set("Referrer-Policy", policy.ReferrerPolicy)
+	set("Permissions-Policy", policy.PermissionsPolicy)
+
+	nosniff := ""
+	if policy.ContentTypeOptions {
+		nosniff = "nosniff"
+	}
+	set("X-Content-Type-Options", nosniff)
+
+	if policy.HSTSMaxAge > 0 && context.App().Config.secure(context.Request()) {
+		hsts := fmt.Sprintf("max-age=%d", policy.HSTSMaxAge)
+		if policy.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if policy.HSTSPreload {
+			hsts += "; preload"
+		}
+		header.Set("Strict-Transport-Security", hsts)
+	}
+}
+
+// isReport returns true if request is violation report for policy report URI
+func (policy *SecureHeaders) isReport(r *http.Request) bool {
+	return len(policy.ReportURI) > 0 && r.Method == "POST" && r.URL.Path == policy.ReportURI
+}
+
+// maxReportSize is maximal size of violation report body which is read
+const maxReportSize = 4096
+
+// limitReport limits size of request body if request is violation report for policy,
+// body is read before request is handled, so it must be limited before that
+func (policy *SecureHeaders) limitReport(w http.ResponseWriter, r *http.Request) {
+	if policy.isReport(r) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxReportSize)
+	}
+}
+
+// logReport logs violation report sent by browser, report is quoted, so it can't forge log lines
+func logReport(context Context) {
+	report := strings.TrimSpace(context.Body())
+	loggy.Warning.Printf("%s security policy violation: %q\n", context.RemoteAddr(), report)
+	context.Response(Response_No_Content)
+}
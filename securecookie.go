@@ -0,0 +1,266 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned when reading signed and encrypted cookies
+var (
+	ErrCookieTampered = errors.New("cookie: value is tampered or signed with unknown key")
+	ErrCookieExpired  = errors.New("cookie: signed value is expired")
+	ErrNoCookieKeys   = errors.New("cookie: no keys configured")
+)
+
+// minSigningKeyLength is minimal length of keys for signed cookies, shorter HMAC keys can be guessed
+const minSigningKeyLength = 32
+
+// defaultSignedCookieMaxAge is used when cookie_signed_max_age is not set
+const defaultSignedCookieMaxAge = 30 * 24 * time.Hour
+
+// cookieKeys are keys for signed and encrypted cookies, first key signs or encrypts,
+// all keys are used for verifying and decrypting, so keys can be rotated
+type cookieKeys struct {
+	signing [][]byte
+	aeads   []cipher.AEAD
+
+	// configuration values from which keys were parsed
+	signingKeys    []string
+	encryptionKeys []string
+}
+
+// cookieKeys returns keys parsed from configuration, parsed keys are reused until keys in configuration
+// are changed, invalid keys are not reused, so error is returned until configuration is fixed
+func (config *configStruct) cookieKeys() (*cookieKeys, error) {
+	config.keysMu.Lock()
+	defer config.keysMu.Unlock()
+
+	if keys := config.keys; keys != nil && equalStrings(keys.signingKeys, config.CookieSigningKeys) &&
+		equalStrings(keys.encryptionKeys, config.CookieEncryptionKeys) {
+		return keys, nil
+	}
+
+	keys, err := parseCookieKeys(config.CookieSigningKeys, config.CookieEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	config.keys = keys
+	return keys, nil
+}
+
+func parseCookieKeys(signing, encryption []string) (*cookieKeys, error) {
+	keys := &cookieKeys{
+		signingKeys:    append([]string(nil), signing...),
+		encryptionKeys: append([]string(nil), encryption...),
+	}
+	for _, key := range signing {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("cookie_signing_keys: %v", err)
+		}
+		if len(b) < minSigningKeyLength {
+			return nil, fmt.Errorf("cookie_signing_keys: key is %d bytes, at least %d bytes required", len(b), minSigningKeyLength)
+		}
+		keys.signing = append(keys.signing, b)
+	}
+	for _, key := range encryption {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("cookie_encryption_keys: %v", err)
+		}
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, fmt.Errorf("cookie_encryption_keys: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("cookie_encryption_keys: %v", err)
+		}
+		keys.aeads = append(keys.aeads, aead)
+	}
+	return keys, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// signedCookieMaxAge returns how long signed values are accepted after they are written
+func (config *configStruct) signedCookieMaxAge() time.Duration {
+	if config.CookieSignedMaxAge > 0 {
+		return time.Duration(config.CookieSignedMaxAge) * time.Second
+	}
+	return defaultSignedCookieMaxAge
+}
+
+// sign returns value with time when it was signed and signature, cookie name is signed too,
+// so value can't be moved to other cookie
+func (keys *cookieKeys) sign(name, value string, now time.Time) (string, error) {
+	if len(keys.signing) == 0 {
+		return "", ErrNoCookieKeys
+	}
+	issued := make([]byte, 8)
+	binary.BigEndian.PutUint64(issued, uint64(now.Unix()))
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(value)) + "." + enc.EncodeToString(issued) + "." +
+		enc.EncodeToString(cookieMAC(keys.signing[0], name, value, issued)), nil
+}
+
+// verify returns signed value, values signed more than maxAge ago are rejected with ErrCookieExpired
+func (keys *cookieKeys) verify(name, signed string, maxAge time.Duration, now time.Time) (string, error) {
+	if len(keys.signing) == 0 {
+		return "", ErrNoCookieKeys
+	}
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return "", ErrCookieTampered
+	}
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	issued, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(issued) != 8 {
+		return "", ErrCookieTampered
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+
+	for _, key := range keys.signing {
+		if hmac.Equal(mac, cookieMAC(key, name, string(value), issued)) {
+			if now.Sub(time.Unix(int64(binary.BigEndian.Uint64(issued)), 0)) > maxAge {
+				return "", ErrCookieExpired
+			}
+			return string(value), nil
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+func cookieMAC(key []byte, name, value string, issued []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "=" + value))
+	mac.Write(issued)
+	return mac.Sum(nil)
+}
+
+// encrypt encrypts value with AES-GCM, cookie name is authenticated too
+func (keys *cookieKeys) encrypt(name, value string) (string, error) {
+	if len(keys.aeads) == 0 {
+		return "", ErrNoCookieKeys
+	}
+	aead := keys.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), []byte(name))), nil
+}
+
+func (keys *cookieKeys) decrypt(name, encrypted string) (string, error) {
+	if len(keys.aeads) == 0 {
+		return "", ErrNoCookieKeys
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+
+	for _, aead := range keys.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(name)); err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", ErrCookieTampered
+}
+
+// SetSignedCookie writes cookie with value signed with first key from cookie_signing_keys,
+// value is readable by client, but can't be changed
+func (out *output) SetSignedCookie(name, value string) error {
+	keys, err := out.keys()
+	if err != nil {
+		return err
+	}
+	signed, err := keys.sign(name, value, time.Now())
+	if err != nil {
+		return err
+	}
+	out.SetCookieValue(name, signed)
+	return nil
+}
+
+// SetEncryptedCookie writes cookie with value encrypted with first key from cookie_encryption_keys
+func (out *output) SetEncryptedCookie(name, value string) error {
+	keys, err := out.keys()
+	if err != nil {
+		return err
+	}
+	encrypted, err := keys.encrypt(name, value)
+	if err != nil {
+		return err
+	}
+	out.SetCookieValue(name, encrypted)
+	return nil
+}
+
+// SignedCookieValue returns value of signed cookie, returns http.ErrNoCookie if there is no cookie,
+// ErrCookieTampered if signature is not valid and ErrCookieExpired if value is older than cookie_signed_max_age
+func (in *defaultInput) SignedCookieValue(name string) (string, error) {
+	cookie, err := in.request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	keys, err := in.app.Config.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+	return keys.verify(name, cookie.Value, in.app.Config.signedCookieMaxAge(), time.Now())
+}
+
+// EncryptedCookieValue returns decrypted value of encrypted cookie, returns http.ErrNoCookie
+// if there is no cookie and ErrCookieTampered if cookie can't be decrypted
+func (in *defaultInput) EncryptedCookieValue(name string) (string, error) {
+	cookie, err := in.request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	keys, err := in.app.Config.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+	return keys.decrypt(name, cookie.Value)
+}
+
+// keys returns cookie keys of application configuration
+func (out *output) keys() (*cookieKeys, error) {
+	if out.config == nil {
+		return nil, ErrNoCookieKeys
+	}
+	return out.config.cookieKeys()
+}
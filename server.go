@@ -0,0 +1,248 @@
+package core
+
+import (
+	gocontext "context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jzaikovs/core/loggy"
+	"github.com/jzaikovs/core/session"
+)
+
+// server holds state of running application server
+type server struct {
+	http     *http.Server
+	redirect *http.Server // plain HTTP server redirecting to HTTPS
+	listener net.Listener
+	stopGC   func()
+	once     sync.Once
+	done     chan struct{} // closed when shutdown is complete
+}
+
+// Run function will initiaate default config load and start listening for requests
+func Run() {
+	if err := RunContext(gocontext.Background()); err != nil {
+		loggy.Error.Println(err)
+	}
+}
+
+// RunContext starts listening for requests with default application,
+// server is gracefully shut down when ctx is done or process receives SIGINT or SIGTERM
+func RunContext(ctx gocontext.Context) error {
+	loggy.Info.Println("Starting core...")
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr := fmt.Sprintf("%s:%d", DefaultConfig.Host, DefaultConfig.Port)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	loggy.Info.Println("Listening on:", addr)
+
+	return APP.serve(ctx, l)
+}
+
+// OnStart adds function that is called before application starts serving requests,
+// if function returns error application is not started
+func (app *App) OnStart(fn func() error) {
+	app.onStart = append(app.onStart, fn)
+}
+
+// OnShutdown adds function that is called after application has finished serving requests
+func (app *App) OnShutdown(fn func() error) {
+	app.onShutdown = append(app.onShutdown, fn)
+}
+
+func (app *App) config() *configStruct {
+	if app.Config == nil {
+		return DefaultConfig
+	}
+	return app.Config
+}
+
+// serve serves requests from listener until ctx is done or application is shut down,
+// configuration is checked and listeners are opened before OnStart functions are called
+func (app *App) serve(ctx gocontext.Context, l net.Listener) error {
+	config := app.config()
+
+	var rl net.Listener // listener of HTTPS redirect server
+	fail := func(err error) error {
+		l.Close()
+		if rl != nil {
+			rl.Close()
+		}
+		return err
+	}
+
+	if err := config.applySession(); err != nil {
+		return fail(err)
+	}
+
+	// invalid cookie keys are reported on start, not when cookie is used first time
+	if _, err := config.cookieKeys(); err != nil {
+		return fail(err)
+	}
+
+	if config.FCGI && len(config.TLSCert) > 0 {
+		return fail(errors.New("core: TLS is not supported with FastCGI, TLS is terminated by web server"))
+	}
+
+	tlsConfig, certs, err := config.tlsConfig()
+	if err != nil {
+		return fail(err)
+	}
+
+	srv := &server{
+		http: &http.Server{
+			TLSConfig:         tlsConfig,
+			Handler:           app,
+			ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+			ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout) * time.Second,
+			WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+			IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		},
+		listener: l,
+		done:     make(chan struct{}),
+	}
+
+	if tlsConfig != nil && !config.HTTP2 {
+		// non-nil map disables automatic HTTP/2
+		srv.http.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	if tlsConfig != nil && config.RedirectPort > 0 {
+		addr := fmt.Sprintf("%s:%d", config.Host, config.RedirectPort)
+		if rl, err = net.Listen("tcp", addr); err != nil {
+			return fail(err)
+		}
+
+		loggy.Info.Println("Redirecting to HTTPS from:", addr)
+
+		srv.redirect = &http.Server{
+			Handler:           redirectHandler(config.Port),
+			ReadHeaderTimeout: srv.http.ReadHeaderTimeout,
+			IdleTimeout:       srv.http.IdleTimeout,
+		}
+	}
+
+	for _, fn := range app.onStart {
+		if err := fn(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if srv.redirect != nil {
+		go srv.redirect.Serve(rl)
+	}
+
+	if config.SessionGCInterval > 0 && (session.MaxLifetime > 0 || session.IdleTimeout > 0) {
+		srv.stopGC = session.StartGC(time.Duration(config.SessionGCInterval) * time.Second)
+	}
+
+	app.mu.Lock()
+	app.srv = srv
+	app.mu.Unlock()
+
+	if certs != nil {
+		// watcher is stopped when srv.done is closed
+		go certs.watch(srv.done)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		switch {
+		case config.FCGI:
+			errc <- fcgi.Serve(l, app)
+		case tlsConfig != nil:
+			errc <- srv.http.ServeTLS(l, "", "")
+		default:
+			errc <- srv.http.Serve(l)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx := gocontext.Background()
+		if config.ShutdownTimeout > 0 {
+			var cancel gocontext.CancelFunc
+			shutdownCtx, cancel = gocontext.WithTimeout(shutdownCtx, time.Duration(config.ShutdownTimeout)*time.Second)
+			defer cancel()
+		}
+		return app.Shutdown(shutdownCtx)
+
+	case err := <-errc:
+		if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+			// shut down by App.Shutdown, wait until it is done
+			<-srv.done
+			return nil
+		}
+		// server failed, OnShutdown functions are called same as on shutdown
+		if srv.redirect != nil {
+			srv.redirect.Close()
+		}
+		app.stop(srv)
+		return err
+	}
+}
+
+// stop releases server resources and calls OnShutdown functions, only first call has effect
+func (app *App) stop(srv *server) {
+	srv.once.Do(func() {
+		if srv.stopGC != nil {
+			srv.stopGC()
+		}
+		for _, fn := range app.onShutdown {
+			if err := fn(); err != nil {
+				loggy.Error.Println("shutdown:", err)
+			}
+		}
+		close(srv.done)
+	})
+}
+
+// Shutdown gracefully shuts down application server, waiting for in-flight requests
+// until ctx is done, after that registered OnShutdown functions are called
+func (app *App) Shutdown(ctx gocontext.Context) error {
+	app.mu.Lock()
+	srv := app.srv
+	app.mu.Unlock()
+
+	if srv == nil {
+		return nil // not running
+	}
+
+	loggy.Info.Println("Shutting down...")
+
+	var err error
+
+	if srv.redirect != nil {
+		srv.redirect.Shutdown(ctx)
+	}
+
+	if app.config().FCGI {
+		// FastCGI server can't drain requests, only stop accepting new ones
+		err = srv.listener.Close()
+	} else if err = srv.http.Shutdown(ctx); err != nil {
+		loggy.Error.Println("shutdown:", err)
+		srv.http.Close()
+	}
+
+	app.stop(srv)
+
+	<-srv.done
+	return err
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientStore is store which keeps sessions on client side, so sessions
+// can be loaded and stored only using Server of request
+type ClientStore interface {
+	Store
+	// Load reads session from request, returns nil session if request has no valid session
+	Load(server Server) (*Session, error)
+	// Clear removes session from client
+	Clear(session *Session) error
+}
+
+// ErrSessionTooLarge is returned when encrypted session does not fit in cookies
+var ErrSessionTooLarge = errors.New("session: session data too large for cookies")
+
+// CookieStore keeps sessions in AES-GCM encrypted and authenticated cookies, so server keeps no state,
+// session is written in several cookies if it does not fit in one
+type CookieStore struct {
+	aeads []cipher.AEAD
+
+	// Name is name of cookie, additional cookies are named with suffix .1, .2, ...
+	Name string
+	// ChunkSize is maximal length of single cookie value
+	ChunkSize int
+	// MaxChunks is maximal number of cookies used for one session
+	MaxChunks int
+}
+
+// NewCookieStore creates cookie session store, keys must be 16, 24 or 32 bytes long.
+// First key is used for encryption, all keys are used for decryption,
+// so keys can be rotated by adding new key in front of old ones
+func NewCookieStore(keys ...[]byte) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: cookie store needs at least one key")
+	}
+
+	store := &CookieStore{
+		Name:      "session",
+		ChunkSize: 3800,
+		MaxChunks: 4,
+	}
+
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		store.aeads = append(store.aeads, aead)
+	}
+
+	return store, nil
+}
+
+// cookiePayload is encrypted content of session cookies
+type cookiePayload struct {
+	SID      string          `json:"sid"`
+	Accessed int64           `json:"accessed"`
+	Session  json.RawMessage `json:"session"`
+}
+
+// Load decrypts session from request cookies
+func (store *CookieStore) Load(server Server) (*Session, error) {
+	value, ok := store.read(server)
+	if !ok {
+		return nil, nil
+	}
+
+	plain, err := store.decrypt(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload cookiePayload
+	if err := json.Unmarshal(plain, &payload); err != nil {
+		return nil, err
+	}
+
+	session := &Session{sid: payload.SID, accessed: time.Unix(payload.Accessed, 0)}
+	if err := json.Unmarshal(payload.Session, session); err != nil {
+		return nil, err
+	}
+
+	// cookies are written on each request, so access time is updated
+	session.snapshot = nil
+	return session, nil
+}
+
+// Save encrypts session and writes it in cookies of linked request
+func (store *CookieStore) Save(session *Session) error {
+	if session.server == nil {
+		return errors.New("session: cookie session is not linked to request")
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(cookiePayload{
+		SID:      session.sid,
+		Accessed: time.Now().Unix(),
+		Session:  data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return store.write(session.server, store.encrypt(plain))
+}
+
+// Clear removes session cookies
+func (store *CookieStore) Clear(session *Session) error {
+	if session.server == nil {
+		return nil
+	}
+	store.clear(session.server, 0)
+	return nil
+}
+
+// Get returns nil, cookie sessions can be loaded only from request using Load
+func (store *CookieStore) Get(sid string) (*Session, error) {
+	return nil, nil
+}
+
+// Delete does nothing, use Clear to remove session cookies
+func (store *CookieStore) Delete(sid string) error {
+	return nil
+}
+
+// Touch does nothing, access time is updated when session is saved
+func (store *CookieStore) Touch(sid string) error {
+	return nil
+}
+
+// GC does nothing, expired sessions are rejected when loaded
+func (store *CookieStore) GC(before time.Time) error {
+	return nil
+}
+
+func (store *CookieStore) chunkName(i int) string {
+	if i == 0 {
+		return store.Name
+	}
+	return store.Name + "." + strconv.Itoa(i)
+}
+
+// read joins value from cookie chunks, first cookie starts with chunk count
+func (store *CookieStore) read(server Server) (string, bool) {
+	first, ok := server.CookieValue(store.Name)
+	if !ok {
+		return "", false
+	}
+
+	i := strings.IndexByte(first, '.')
+	if i < 0 {
+		return "", false
+	}
+
+	n, err := strconv.Atoi(first[:i])
+	if err != nil || n < 1 || n > store.MaxChunks {
+		return "", false
+	}
+
+	value := first[i+1:]
+	for i := 1; i < n; i++ {
+		chunk, ok := server.CookieValue(store.chunkName(i))
+		if !ok {
+			return "", false
+		}
+		value += chunk
+	}
+
+	return value, true
+}
+
+// write splits value in cookie chunks and removes chunks that are not used anymore
+func (store *CookieStore) write(server Server, value string) error {
+	n := (len(value) + store.ChunkSize - 1) / store.ChunkSize
+	if n > store.MaxChunks {
+		return ErrSessionTooLarge
+	}
+
+	for i := 0; i < n; i++ {
+		chunk := value[i*store.ChunkSize:]
+		if len(chunk) > store.ChunkSize {
+			chunk = chunk[:store.ChunkSize]
+		}
+		if i == 0 {
+			chunk = strconv.Itoa(n) + "." + chunk
+		}
+		server.SetCookieValue(store.chunkName(i), chunk)
+	}
+
+	store.clear(server, n)
+	return nil
+}
+
+// clear removes chunks starting from chunk from
+func (store *CookieStore) clear(server Server, from int) {
+	for i := from; i < store.MaxChunks; i++ {
+		if _, ok := server.CookieValue(store.chunkName(i)); ok {
+			deleteCookie(server, store.chunkName(i))
+		}
+	}
+}
+
+func (store *CookieStore) encrypt(plain []byte) string {
+	aead := store.aeads[0]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("session: can't generate nonce: " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(store.Name)))
+}
+
+// decrypt tries all keys, so cookies encrypted with old keys are still valid
+func (store *CookieStore) decrypt(value string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, aead := range store.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(store.Name)); err == nil {
+			return plain, nil
+		}
+	}
+
+	return nil, fmt.Errorf("session: can't decrypt session cookie")
+}
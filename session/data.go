@@ -0,0 +1,113 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/jzaikovs/t"
+)
+
+// lockerMu guards creation of session locks
+var lockerMu sync.Mutex
+
+// locker returns lock of session data, lock is shared by all copies of session
+// that are handed out to concurrent requests, sessions created with New or loaded
+// from store already have lock, for other sessions lock is created on first use
+func (session *Session) locker() *sync.Mutex {
+	lockerMu.Lock()
+	defer lockerMu.Unlock()
+	if session.mu == nil {
+		session.mu = new(sync.Mutex)
+	}
+	return session.mu
+}
+
+// Get returns value from session data, it is safe to use from concurrent requests
+func (session *Session) Get(key string) t.T {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	return t.T{Value: session.Data[key]}
+}
+
+// Set sets value in session data, it is safe to use from concurrent requests
+func (session *Session) Set(key string, value interface{}) {
+	mu := session.locker()
+	mu.Lock()
+	session.Data[key] = value
+	mu.Unlock()
+}
+
+// Delete removes value from session data, it is safe to use from concurrent requests
+func (session *Session) Delete(key string) {
+	mu := session.locker()
+	mu.Lock()
+	delete(session.Data, key)
+	mu.Unlock()
+}
+
+// Update calls fn with session data while session is locked, so several values
+// can be read and changed atomically, fn must not call other session methods.
+// Concurrent requests share session data only with MemoryStore, other stores load
+// copy of session for each request, see Store
+func (session *Session) Update(fn func(data t.Map)) {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	fn(session.Data)
+}
+
+// clone returns copy of session for single request, copy shares data and its lock with session
+func (session *Session) clone() *Session {
+	c := *session
+	c.server = nil
+	return &c
+}
+
+// merged returns serialized session with data changes made since session was loaded from store
+// applied to data of stored session, so concurrent requests which change different keys
+// don't overwrite changes of each other, if same key is changed by several requests
+// last saved value is kept, session itself is not changed
+func (session *Session) merged(stored *Session) ([]byte, error) {
+	if session.snapshot == nil || stored == nil {
+		return json.Marshal(session)
+	}
+	var loaded record
+	if err := json.Unmarshal(session.snapshot, &loaded); err != nil {
+		return nil, err
+	}
+
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+
+	data := make(t.Map, len(stored.Data))
+	for key, value := range stored.Data {
+		data[key] = value
+	}
+	for key, value := range session.Data {
+		if old, ok := loaded.Data[key]; !ok || !sameJSON(old, value) {
+			data[key] = value
+		}
+	}
+	for key := range loaded.Data {
+		if _, ok := session.Data[key]; !ok {
+			delete(data, key)
+		}
+	}
+	return json.Marshal(session.record(data))
+}
+
+// sameJSON compares values by their serialized form, loaded values have JSON types
+func sameJSON(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
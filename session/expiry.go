@@ -0,0 +1,90 @@
+package session
+
+import (
+	"time"
+
+	"github.com/jzaikovs/core/loggy"
+)
+
+// MaxLifetime is absolute session lifetime counted from session creation, zero means no limit
+var MaxLifetime time.Duration
+
+// IdleTimeout is time after which not accessed session expires, zero means no limit
+var IdleTimeout time.Duration
+
+// Expired returns true if session has reached its absolute lifetime or idle timeout
+func (session *Session) Expired() bool {
+	now := time.Now()
+
+	if MaxLifetime > 0 && !session.created.IsZero() && now.Sub(session.created) > MaxLifetime {
+		return true
+	}
+
+	if IdleTimeout > 0 && !session.accessed.IsZero() && now.Sub(session.accessed) > IdleTimeout {
+		return true
+	}
+
+	return false
+}
+
+// Created returns time when session was created
+func (session *Session) Created() time.Time {
+	return session.created
+}
+
+// Accessed returns time when session was last accessed
+func (session *Session) Accessed() time.Time {
+	return session.accessed
+}
+
+// Touch marks session as accessed now
+func (session *Session) Touch() error {
+	session.accessed = time.Now()
+	return session.store.Touch(session.sid)
+}
+
+// Renew creates new ID for session and restarts its lifetime, session data is kept
+func (session *Session) Renew() {
+	key := session.key
+	session.Destroy()
+	session.CreateCookie("")
+	session.key = key
+}
+
+// StartGC starts background goroutine which removes expired sessions from DefaultStore
+// every interval, returned function stops it
+func StartGC(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := GC(); err != nil {
+					loggy.Error.Println("session: gc:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// GC removes expired sessions from DefaultStore, sessions are removed by access time,
+// sessions that are not accessed since MaxLifetime have expired too
+func GC() error {
+	timeout := IdleTimeout
+	if timeout == 0 || (MaxLifetime > 0 && MaxLifetime < timeout) {
+		timeout = MaxLifetime
+	}
+
+	if timeout == 0 {
+		return nil // sessions never expires
+	}
+
+	return DefaultStore.GC(time.Now().Add(-timeout))
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps each session in separate JSON file in directory,
+// file modification time is used as session access time. Session data changed by request
+// is merged with stored data on save, so concurrent requests of same client which change
+// different keys don't lose changes, changes are merged only within one process
+type FileStore struct {
+	dir string
+	mu  sync.Mutex // makes read, merge and write of session atomic
+}
+
+// NewFileStore creates file session store, directory is created if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (store *FileStore) path(sid string) (string, error) {
+	if len(sid) == 0 || strings.ContainsAny(sid, `/\.`) {
+		return "", errors.New("session: invalid session id")
+	}
+	return filepath.Join(store.dir, sid+".json"), nil
+}
+
+// Get reads session from file
+func (store *FileStore) Get(sid string) (*Session, error) {
+	path, err := store.path(sid)
+	if err != nil {
+		return nil, nil // such session can't exist
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{sid: sid, accessed: info.ModTime()}
+	if err := json.Unmarshal(b, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Save merges session data changes with stored session and writes session to file,
+// file is replaced atomically, loaded session which file was removed meanwhile is not written
+func (store *FileStore) Save(session *Session) error {
+	path, err := store.path(session.sid)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var stored *Session
+	if session.loaded {
+		if stored, err = store.Get(session.sid); err != nil {
+			return err
+		}
+		if stored == nil {
+			return nil // destroyed by concurrent request
+		}
+	}
+
+	b, err := session.merged(stored)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(store.dir, ".session")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete removes session file
+func (store *FileStore) Delete(sid string) error {
+	path, err := store.path(sid)
+	if err != nil {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Touch updates session file modification time
+func (store *FileStore) Touch(sid string) error {
+	path, err := store.path(sid)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GC removes session files which were not modified since before
+func (store *FileStore) GC(before time.Time) error {
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range files {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		if info.ModTime().Before(before) {
+			os.Remove(filepath.Join(store.dir, info.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"github.com/jzaikovs/t"
+)
+
+// FlashKey is reserved key in session Data where flash messages are kept
+const FlashKey = "_flash"
+
+// Flash adds value to flash messages under key, flash messages are kept in session
+// until they are read with Flashes
+func (session *Session) Flash(key string, value interface{}) {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+
+	flashes := session.flashes()
+	if flashes == nil {
+		flashes = make(map[string]interface{})
+		session.Data[FlashKey] = flashes
+	}
+
+	values, _ := flashes[key].([]interface{})
+	flashes[key] = append(values, value)
+}
+
+// Flashes returns flash messages under key and removes them from session
+func (session *Session) Flashes(key string) []interface{} {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+
+	flashes := session.flashes()
+	if flashes == nil {
+		return nil
+	}
+
+	values, _ := flashes[key].([]interface{})
+	delete(flashes, key)
+	if len(flashes) == 0 {
+		delete(session.Data, FlashKey)
+	}
+	return values
+}
+
+// AllFlashes returns all flash messages by key and removes them from session
+func (session *Session) AllFlashes() map[string][]interface{} {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+
+	flashes := session.flashes()
+	if flashes == nil {
+		return nil
+	}
+
+	all := make(map[string][]interface{}, len(flashes))
+	for key, value := range flashes {
+		values, _ := value.([]interface{})
+		all[key] = values
+	}
+	delete(session.Data, FlashKey)
+	return all
+}
+
+// flashes returns flash namespace of Data, after session is loaded from store
+// namespace is plain map
+func (session *Session) flashes() map[string]interface{} {
+	switch flashes := session.Data[FlashKey].(type) {
+	case map[string]interface{}:
+		return flashes
+	case t.Map:
+		return flashes
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jzaikovs/t"
+)
+
+// Principal describes user of authorized session
+type Principal struct {
+	UserID      string
+	Roles       []string
+	Permissions []string
+	AuthTime    time.Time
+}
+
+// HasRole returns true if principal has role
+func (principal *Principal) HasRole(role string) bool {
+	return contains(principal.Roles, role)
+}
+
+// HasPermission returns true if principal has permission
+func (principal *Principal) HasPermission(permission string) bool {
+	return contains(principal.Permissions, permission)
+}
+
+// Login authorizes session for user with roles and permissions, session gets new ID
+// same as with Authorize, salt/key should be used in validate call
+func (session *Session) Login(userID, salt string, roles, permissions []string) {
+	mu := session.locker()
+	mu.Lock()
+	session.userID = userID
+	session.roles = append([]string(nil), roles...)
+	session.permissions = append([]string(nil), permissions...)
+	mu.Unlock()
+	session.Authorize(salt)
+}
+
+// Principal returns user of session, nil if session is not authorized
+func (session *Session) Principal() *Principal {
+	if !session.authorized {
+		return nil
+	}
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	return &Principal{
+		UserID:      session.userID,
+		Roles:       append([]string(nil), session.roles...),
+		Permissions: append([]string(nil), session.permissions...),
+		AuthTime:    session.authTime,
+	}
+}
+
+// UserID returns ID of user session is authorized for
+func (session *Session) UserID() string {
+	return session.userID
+}
+
+// AuthTime returns time when session was authorized
+func (session *Session) AuthTime() time.Time {
+	return session.authTime
+}
+
+// HasRole returns true if session is authorized and user has role
+func (session *Session) HasRole(role string) bool {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	return session.authorized && contains(session.roles, role)
+}
+
+// HasPermission returns true if session is authorized and user has permission
+func (session *Session) HasPermission(permission string) bool {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	return session.authorized && contains(session.permissions, permission)
+}
+
+// Grant adds roles to session user, it is safe to use from concurrent requests
+func (session *Session) Grant(roles ...string) {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, role := range roles {
+		if !contains(session.roles, role) {
+			session.roles = append(session.roles, role)
+		}
+	}
+}
+
+// Allow adds permissions to session user, it is safe to use from concurrent requests
+func (session *Session) Allow(permissions ...string) {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, permission := range permissions {
+		if !contains(session.permissions, permission) {
+			session.permissions = append(session.permissions, permission)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// transientStore is store for sessions which are not stored
+type transientStore struct{}
+
+func (transientStore) Get(sid string) (*Session, error) { return nil, nil }
+func (transientStore) Save(session *Session) error      { return nil }
+func (transientStore) Delete(sid string) error          { return nil }
+func (transientStore) Touch(sid string) error           { return nil }
+func (transientStore) GC(before time.Time) error        { return nil }
+
+// NewTransient creates authorized session for principal of request authenticated by credentials
+// sent with request (tokens, API keys, ...), session lives only while request is handled,
+// no cookie is set and session is not stored
+func NewTransient(server Server, principal *Principal) *Session {
+	now := time.Now()
+	session := &Session{
+		sid:         newID(),
+		authorized:  true,
+		server:      server,
+		store:       transientStore{},
+		mu:          new(sync.Mutex),
+		userID:      principal.UserID,
+		roles:       append([]string(nil), principal.Roles...),
+		permissions: append([]string(nil), principal.Permissions...),
+		authTime:    principal.AuthTime,
+		created:     now,
+		accessed:    now,
+		Data:        make(t.Map),
+	}
+	return session
+}
+
+// Transient returns true if session is created by NewTransient
+func (session *Session) Transient() bool {
+	_, ok := session.store.(transientStore)
+	return ok
+}
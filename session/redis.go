@@ -0,0 +1,295 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisStore keeps sessions in server that speaks Redis protocol (Redis, KeyDB, Valkey, ...),
+// sessions expire using key TTL, so GC does nothing and idle timeout is store TTL,
+// with zero TTL sessions are kept until they are deleted
+type RedisStore struct {
+	addr string
+	ttl  time.Duration
+	pool chan *redisConn
+
+	// Prefix is added to session ID to make key
+	Prefix string
+	// Password is used for AUTH command if not empty
+	Password string
+	// DB is database selected with SELECT command
+	DB int
+	// Timeout is used for connecting and each command
+	Timeout time.Duration
+}
+
+// NewRedisStore creates session store for Redis server in addr, sessions are removed
+// when they are not accessed for ttl
+func NewRedisStore(addr string, ttl time.Duration) *RedisStore {
+	return &RedisStore{
+		addr:    addr,
+		ttl:     ttl,
+		pool:    make(chan *redisConn, 8),
+		Prefix:  "session:",
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Get loads session from Redis
+func (store *RedisStore) Get(sid string) (*Session, error) {
+	reply, err := store.do("GET", store.Prefix+sid)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("session: unexpected redis reply %v", reply)
+	}
+
+	session := &Session{sid: sid, accessed: time.Now()}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// redisReplace replaces value of key only if it was not changed, optional third argument is TTL
+const redisReplace = `if redis.call('GET', KEYS[1]) ~= ARGV[1] then return 0 end
+if ARGV[3] then return redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3]) end
+return redis.call('SET', KEYS[1], ARGV[2])`
+
+// Save stores new session or merges changes of loaded session with stored session and resets its TTL,
+// stored session is replaced only if it was not changed since it was read, otherwise merge is repeated,
+// loaded session which was removed meanwhile is not saved again
+func (store *RedisStore) Save(session *Session) error {
+	key := store.Prefix + session.sid
+
+	if !session.loaded {
+		data, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		_, err = store.do(store.ttlArgs("SET", key, string(data))...)
+		return err
+	}
+
+	for i := 0; i < saveRetries; i++ {
+		reply, err := store.do("GET", key)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return nil // destroyed by concurrent request or expired
+		}
+		current, ok := reply.([]byte)
+		if !ok {
+			return fmt.Errorf("session: unexpected redis reply %v", reply)
+		}
+
+		stored := &Session{sid: session.sid}
+		if err := json.Unmarshal(current, stored); err != nil {
+			return err
+		}
+		data, err := session.merged(stored)
+		if err != nil {
+			return err
+		}
+
+		reply, err = store.do(store.ttlArgs("EVAL", redisReplace, "1", key, string(current), string(data))...)
+		if err != nil {
+			return err
+		}
+		if reply != int64(0) {
+			return nil
+		}
+	}
+	return ErrConflict
+}
+
+// ttlArgs adds store TTL in milliseconds to command arguments, zero TTL is not added
+func (store *RedisStore) ttlArgs(args ...string) []string {
+	if store.ttl <= 0 {
+		return args
+	}
+	if args[0] == "SET" {
+		return append(args, "PX", store.millis())
+	}
+	return append(args, store.millis())
+}
+
+// Delete removes session from Redis
+func (store *RedisStore) Delete(sid string) error {
+	_, err := store.do("DEL", store.Prefix+sid)
+	return err
+}
+
+// Touch resets session TTL, zero TTL means that session has no TTL
+func (store *RedisStore) Touch(sid string) error {
+	if store.ttl <= 0 {
+		return nil
+	}
+	_, err := store.do("PEXPIRE", store.Prefix+sid, store.millis())
+	return err
+}
+
+// GC does nothing, Redis removes expired keys itself
+func (store *RedisStore) GC(before time.Time) error {
+	return nil
+}
+
+func (store *RedisStore) millis() string {
+	return strconv.FormatInt(int64(store.ttl/time.Millisecond), 10)
+}
+
+// do executes command using connection from pool
+func (store *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := store.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(store.Timeout, args...)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			// connection state is unknown after network error
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	select {
+	case store.pool <- conn:
+	default:
+		conn.Close()
+	}
+
+	return reply, err
+}
+
+func (store *RedisStore) conn() (*redisConn, error) {
+	select {
+	case conn := <-store.pool:
+		return conn, nil
+	default:
+	}
+
+	c, err := net.DialTimeout("tcp", store.addr, store.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+
+	if len(store.Password) > 0 {
+		if _, err := conn.do(store.Timeout, "AUTH", store.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if store.DB != 0 {
+		if _, err := conn.do(store.Timeout, "SELECT", strconv.Itoa(store.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// redisError is error reply from server
+type redisError string
+
+func (err redisError) Error() string {
+	return "session: redis: " + string(err)
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// do writes command and reads its reply
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return conn.read()
+}
+
+// read reads single reply, nil bulk string is returned as nil
+func (conn *redisConn) read() (interface{}, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("session: redis: bad reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = conn.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, errors.New("session: redis: bad reply")
+}
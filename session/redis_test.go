@@ -0,0 +1,119 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/jzaikovs/t"
+)
+
+// fakeRedis is server which understands only commands of RedisStore, EVAL runs redisReplace
+type fakeRedis struct {
+	net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{Listener: l, values: make(map[string]string)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(c)
+		}
+	}()
+	return server
+}
+
+func (server *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	conn := &redisConn{Conn: c, reader: bufio.NewReader(c)}
+	for {
+		request, err := conn.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		c.Write([]byte(server.do(args)))
+	}
+}
+
+func (server *fakeRedis) do(args []string) string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.commands = append(server.commands, args[0]+" "+strings.Join(args[2:], " "))
+	switch args[0] {
+	case "GET":
+		value, ok := server.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		server.values[args[1]] = args[2]
+	case "DEL":
+		delete(server.values, args[1])
+	case "EVAL":
+		if args[1] != redisReplace {
+			return "-ERR unknown script\r\n"
+		}
+		if value, ok := server.values[args[3]]; !ok || value != args[4] {
+			return ":0\r\n"
+		}
+		server.values[args[3]] = args[5]
+	case "PEXPIRE":
+	default:
+		return "-ERR unknown command\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func TestFakeRedisStore(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	store := NewRedisStore(server.Addr().String(), time.Minute)
+	testStore(t, store)
+	testConcurrentSave(t, store)
+}
+
+// zero TTL keeps sessions without expiration, PX 0 is rejected by Redis
+func TestRedisStoreNoTTL(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.Close()
+
+	store := NewRedisStore(server.Addr().String(), 0)
+	s := &Session{sid: "sid", Data: make(Map)}
+	if err := store.Save(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Touch("sid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("sid"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.commands) != 2 || strings.Contains(server.commands[0], "PX") || !strings.HasPrefix(server.commands[0], "SET") {
+		t.Fatal("Bad commands for session without TTL", server.commands)
+	}
+}
@@ -1,22 +1,39 @@
 package session
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/rand"
 	_ "crypto/sha1" // default hash package
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/jzaikovs/core/loggy"
 	"github.com/jzaikovs/t"
 )
 
 // SessionCookieName stores name of cookie used to link request with session data
 var SessionCookieName = "sid"
 
-// DefaultHash is crypto hash used to makce session ID
+// DefaultHash is crypto hash used for session fingerprints,
+// before session IDs were random it was used to make session ID
 var DefaultHash = crypto.SHA1
 
+// IDLength is number of random bytes in session ID
+var IDLength = 32
+
+// BindUserAgent binds session to user agent of client which created it,
+// session with different user agent is not accepted
+var BindUserAgent = false
+
+// BindRemoteAddr binds session to IP address of client which created it
+var BindRemoteAddr = false
+
 // Server is interface/trates that should be implemented to use this package
 type Server interface {
 	CookieValue(name string) (string, bool)
@@ -25,29 +42,135 @@ type Server interface {
 	RemoteAddr() string
 }
 
-var sessions = make(map[string]*Session) // todo: consider map[int] as it is rumored to be faster
-var sessionslock = sync.RWMutex{}
+// cookieDeleter is implemented by servers that can remove cookies from client
+type cookieDeleter interface {
+	DeleteCookie(name string)
+}
+
+// deleteCookie removes cookie, if server can't remove cookies, cookie value is cleared
+func deleteCookie(server Server, name string) {
+	if deleter, ok := server.(cookieDeleter); ok {
+		deleter.DeleteCookie(name)
+		return
+	}
+	server.SetCookieValue(name, "")
+}
 
 // Get will return session for specific SID
 func Get(sid string) (session *Session, ok bool) {
-	sessionslock.RLock()
-	session, ok = sessions[sid]
-	sessionslock.RUnlock()
-	return
+	session, err := DefaultStore.Get(sid)
+	if err != nil {
+		loggy.Error.Println("session:", err)
+		return nil, false
+	}
+	if session == nil {
+		return nil, false
+	}
+	session.store = DefaultStore
+	session.loaded = true
+	return session, true
 }
 
-func (session *Session) save() {
-	sessionslock.Lock()
-	sessions[session.sid] = session
-	sessionslock.Unlock()
+// Save stores session in its store, if session data is not changed
+// since it was loaded, only access time is updated
+func (session *Session) Save() error {
+	if session.destroyed {
+		if store, ok := session.store.(ClientStore); ok {
+			return store.Clear(session)
+		}
+		return nil
+	}
+	if session.snapshot != nil {
+		if b, err := json.Marshal(session); err == nil && bytes.Equal(b, session.snapshot) {
+			return session.store.Touch(session.sid)
+		}
+	}
+	return session.store.Save(session)
 }
 
 // Session represents single session from one user across requests
 type Session struct {
-	sid        string
-	authorized bool
-	server     Server
-	Data       t.Map
+	sid         string
+	authorized  bool
+	server      Server
+	store       Store
+	snapshot    []byte // serialized session as it was loaded from store
+	loaded      bool   // session was loaded from store, it is not saved again if it was removed meanwhile
+	destroyed   bool
+	fingerprint string // hash of bound client attributes, see BindUserAgent and BindRemoteAddr
+	key         string // hash of salt passed to Authorize
+	userID      string
+	roles       []string
+	permissions []string
+	authTime    time.Time
+	mu          *sync.Mutex // guards Data, shared by copies of session
+	created     time.Time
+	accessed    time.Time // access time is tracked by store, so it is not serialized
+	// Data is session data, it can be shared by concurrent requests of same client,
+	// use Get, Set, Delete and Update to access it safely
+	Data t.Map
+}
+
+// record is serialized form of session
+type record struct {
+	Authorized  bool      `json:"authorized"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Key         string    `json:"key,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	Roles       []string  `json:"roles,omitempty"`
+	Permissions []string  `json:"permissions,omitempty"`
+	AuthTime    time.Time `json:"auth_time,omitempty"`
+	Created     time.Time `json:"created"`
+	Data        t.Map     `json:"data"`
+}
+
+// MarshalJSON serializes session data, used by stores
+func (session *Session) MarshalJSON() ([]byte, error) {
+	mu := session.locker()
+	mu.Lock()
+	defer mu.Unlock()
+
+	return json.Marshal(session.record(session.Data))
+}
+
+// record returns serialized form of session with data, session must be locked
+func (session *Session) record(data t.Map) record {
+	return record{
+		Authorized:  session.authorized,
+		Fingerprint: session.fingerprint,
+		Key:         session.key,
+		UserID:      session.userID,
+		Roles:       session.roles,
+		Permissions: session.permissions,
+		AuthTime:    session.authTime,
+		Created:     session.created,
+		Data:        data,
+	}
+}
+
+// UnmarshalJSON loads serialized session data, used by stores
+func (session *Session) UnmarshalJSON(b []byte) error {
+	var r record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+
+	session.authorized = r.Authorized
+	session.fingerprint = r.Fingerprint
+	session.key = r.Key
+	session.userID = r.UserID
+	session.roles = r.Roles
+	session.permissions = r.Permissions
+	session.authTime = r.AuthTime
+	session.created = r.Created
+	session.mu = new(sync.Mutex)
+	session.Data = r.Data
+	if session.Data == nil {
+		session.Data = make(t.Map)
+	}
+
+	session.snapshot = append([]byte(nil), b...)
+	return nil
 }
 
 // Validate validates request for session storage
@@ -58,30 +181,75 @@ func Validate(req *http.Request) (*Session, bool) {
 		return nil, false
 	}
 
-	if sesssion, ok := Get(cookie.Value); ok {
-		return sesssion, sesssion.Valid("", req.UserAgent(), req.RemoteAddr)
+	remoteAddr := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil && len(host) > 0 {
+		remoteAddr = host
+	}
+
+	if sesssion, ok := Get(cookie.Value); ok && !sesssion.Expired() {
+		return sesssion, sesssion.Valid("", req.UserAgent(), remoteAddr)
 	}
 	return nil, false
 }
 
-// New creates new session, using structure which implements Server interface
-func New(server Server) *Session {
-	sid, ok := server.CookieValue(SessionCookieName) // session identifier is store in cookie
+// Find returns existing session of request, unlike New it does not create session
+// and cookie if request has no valid session
+func Find(server Server) (*Session, bool) {
+	session, ok := load(server)
+	// session bound to other client is ignored, but not destroyed
+	if !ok || !session.matches(server.UserAgent(), server.RemoteAddr()) {
+		return nil, false
+	}
 
-	if ok {
-		if session, ok := Get(sid); ok {
-			session.server = server
-			return session
-		}
+	session.server = server
+	if session.Expired() {
+		session.Destroy()
+		return nil, false
+	}
+
+	session.accessed = time.Now()
+	return session, true
+}
+
+// New creates new session, using structure which implements Server interface,
+// if request already has valid session, it is returned
+func New(server Server) *Session {
+	if session, ok := Find(server); ok {
+		return session
 	}
 
 	session := new(Session)
+	session.mu = new(sync.Mutex)
 	session.server = server
+	session.store = DefaultStore
 	session.Data = make(t.Map)
-	session.CreateCookie(time.Now().String())
+	session.CreateCookie("")
 	return session
 }
 
+// load finds session of request, session is read from cookies if DefaultStore is ClientStore,
+// otherwise session identifier is stored in cookie
+func load(server Server) (*Session, bool) {
+	if store, ok := DefaultStore.(ClientStore); ok {
+		session, err := store.Load(server)
+		if err != nil {
+			loggy.Warning.Println("session:", err)
+			return nil, false
+		}
+		if session == nil {
+			return nil, false
+		}
+		session.store = DefaultStore
+		return session, true
+	}
+
+	sid, ok := server.CookieValue(SessionCookieName)
+	if !ok {
+		return nil, false
+	}
+	return Get(sid)
+}
+
 // ID returns session ID which is equeal to cookie session id
 func (session *Session) ID() string {
 	return session.sid
@@ -94,44 +262,115 @@ func (session *Session) IsAuth() bool {
 
 // Destroy destroys session data from session storage
 func (session *Session) Destroy() {
-	sessionslock.Lock()
-	delete(sessions, session.sid)
-	sessionslock.Unlock()
+	session.destroyed = true
+	if err := session.store.Delete(session.sid); err != nil {
+		loggy.Error.Println("session:", err)
+	}
 }
 
 // Authorize marks session as authorized, you can pass sepecific value as "salt"/key.
 // salt/key value should be used in validate call
-// for new sessions ID generation, session id cookie is recreated with different ID
-// to prevent session fixation attacks
+// session id cookie is recreated with different ID to prevent session fixation attacks,
+// to store user of session use Login
 func (session *Session) Authorize(salt string) {
-	session.Destroy()          // destroy old session
-	session.CreateCookie(salt) // create new session with new ID
+	session.Destroy() // destroy old session
 	session.authorized = true
+	session.authTime = time.Now()
+	session.CreateCookie(salt) // create new session with new ID
 }
 
-// CreateCookie will create cookie using salt/key
+// CreateCookie will create cookie with new random session ID, salt/key is stored
+// for validation, session lifetime starts from now
 func (session *Session) CreateCookie(salt string) {
-	sid := salt
-	sid += session.server.UserAgent()
-	sid += session.server.RemoteAddr()
+	session.sid = newID()
+	if _, ok := session.store.(ClientStore); !ok {
+		session.server.SetCookieValue(SessionCookieName, session.sid)
+	}
 
-	h := DefaultHash.New()
-	h.Write([]byte(sid))
+	session.fingerprint = fingerprint(session.server.UserAgent(), session.server.RemoteAddr())
+	session.key = ""
+	if len(salt) > 0 {
+		session.key = hash(salt)
+	}
 
-	session.sid = base64.URLEncoding.EncodeToString(h.Sum(nil))
-	session.server.SetCookieValue(SessionCookieName, session.sid)
+	session.snapshot = nil
+	session.loaded = false
+	session.destroyed = false
+	session.created = time.Now()
+	session.accessed = session.created
 
-	session.save()
+	// client stores write cookies on each save, session is written when request is done
+	if _, ok := session.store.(ClientStore); ok {
+		return
+	}
+	if err := session.store.Save(session); err != nil {
+		loggy.Error.Println("session:", err)
+	}
 }
 
-// Valid validates session
+// Valid validates session with salt/key passed to Authorize and client attributes,
+// user agent and remote address are checked only if session is bound to them
 func (session *Session) Valid(salt, userAgent, remoteAddr string) bool {
+	if len(session.fingerprint) == 0 && len(session.key) == 0 && len(session.ID()) == legacyIDLength {
+		// session created before random session IDs, ID is hash of salt and client attributes
+		return equal(session.ID(), hash(salt+userAgent+remoteAddr))
+	}
+
+	if len(session.key) > 0 || len(salt) > 0 {
+		if !equal(session.key, hash(salt)) {
+			return false
+		}
+	}
+
+	return session.matches(userAgent, remoteAddr)
+}
+
+// matches checks if client attributes match session fingerprint
+func (session *Session) matches(userAgent, remoteAddr string) bool {
+	if len(session.fingerprint) == 0 {
+		return true // not bound
+	}
+	return equal(session.fingerprint, fingerprint(userAgent, remoteAddr))
+}
+
+// length of base64 encoded SHA1 hash, which was used as session ID
+const legacyIDLength = 28
+
+// newID generates random session ID
+func newID() string {
+	b := make([]byte, IDLength)
+	if _, err := rand.Read(b); err != nil {
+		panic("session: can't generate session ID: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// fingerprint returns hash of client attributes session is bound to,
+// returns empty string if session is not bound
+func fingerprint(userAgent, remoteAddr string) string {
+	if !BindUserAgent && !BindRemoteAddr {
+		return ""
+	}
+
+	value := "fingerprint:"
+	if BindUserAgent {
+		value += userAgent
+	}
+	value += "\n"
+	if BindRemoteAddr {
+		value += remoteAddr
+	}
+	return hash(value)
+}
+
+func hash(value string) string {
 	h := DefaultHash.New()
-	h.Write([]byte(salt))
-	h.Write([]byte(userAgent))
-	h.Write([]byte(remoteAddr))
+	h.Write([]byte(value))
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
 
-	return session.ID() == base64.URLEncoding.EncodeToString(h.Sum(nil))
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
 
 // Unlink removes unused references to memory,
@@ -0,0 +1,167 @@
+package session
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SQLStore keeps sessions in database table, table must have following columns:
+//
+//	CREATE TABLE sessions (
+//		sid      VARCHAR(128) PRIMARY KEY,
+//		data     TEXT NOT NULL,
+//		accessed BIGINT NOT NULL
+//	)
+type SQLStore struct {
+	db    *sql.DB
+	table string
+
+	// Placeholder returns query parameter placeholder for n-th (starting from 1) parameter,
+	// default is question mark, for PostgreSQL use DollarPlaceholder
+	Placeholder func(n int) string
+}
+
+// DollarPlaceholder is placeholder function for databases using $1, $2, ... parameters
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// NewSQLStore creates session store which uses database table
+func NewSQLStore(db *sql.DB, table string) *SQLStore {
+	return &SQLStore{
+		db:          db,
+		table:       table,
+		Placeholder: func(int) string { return "?" },
+	}
+}
+
+// query replaces %s in query with table name and placeholders
+func (store *SQLStore) query(query string, params int) string {
+	args := []interface{}{store.table}
+	for i := 1; i <= params; i++ {
+		args = append(args, store.Placeholder(i))
+	}
+	return fmt.Sprintf(query, args...)
+}
+
+// Get loads session from database
+func (store *SQLStore) Get(sid string) (*Session, error) {
+	var data []byte
+	var accessed int64
+
+	err := store.db.QueryRow(store.query(`SELECT data, accessed FROM %s WHERE sid = %s`, 1), sid).Scan(&data, &accessed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{sid: sid, accessed: time.Unix(accessed, 0)}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// saveRetries is how many times session is merged again when it was changed by concurrent request
+const saveRetries = 10
+
+// ErrConflict is returned when session can't be saved because concurrent requests keep changing it
+var ErrConflict = errors.New("session: session changed by concurrent requests")
+
+// Save inserts new session or merges changes of loaded session with stored session,
+// stored session is replaced only if it was not changed since it was read, otherwise merge is repeated,
+// loaded session which was removed meanwhile is not saved again
+func (store *SQLStore) Save(session *Session) error {
+	if !session.loaded {
+		return store.insert(session)
+	}
+
+	for i := 0; i < saveRetries; i++ {
+		var current []byte
+		err := store.db.QueryRow(store.query(`SELECT data FROM %s WHERE sid = %s`, 1), session.sid).Scan(&current)
+		if err == sql.ErrNoRows {
+			return nil // destroyed by concurrent request
+		}
+		if err != nil {
+			return err
+		}
+
+		stored := &Session{sid: session.sid}
+		if err := json.Unmarshal(current, stored); err != nil {
+			return err
+		}
+		data, err := session.merged(stored)
+		if err != nil {
+			return err
+		}
+
+		// some databases report zero affected rows when row is not changed
+		if bytes.Equal(data, current) {
+			return store.Touch(session.sid)
+		}
+
+		result, err := store.db.Exec(store.query(`UPDATE %s SET data = %s, accessed = %s WHERE sid = %s AND data = %s`, 4),
+			string(data), time.Now().Unix(), session.sid, string(current))
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// insert stores new session, existence of session is checked in transaction,
+// because session is saved again with same ID when it is recreated
+func (store *SQLStore) insert(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(store.query(`SELECT 1 FROM %s WHERE sid = %s`, 1), session.sid).Scan(&exists)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(store.query(`INSERT INTO %s (sid, data, accessed) VALUES (%s, %s, %s)`, 3), session.sid, string(data), now)
+	case err == nil:
+		_, err = tx.Exec(store.query(`UPDATE %s SET data = %s, accessed = %s WHERE sid = %s`, 3), string(data), now, session.sid)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes session from database
+func (store *SQLStore) Delete(sid string) error {
+	_, err := store.db.Exec(store.query(`DELETE FROM %s WHERE sid = %s`, 1), sid)
+	return err
+}
+
+// Touch updates session access time
+func (store *SQLStore) Touch(sid string) error {
+	_, err := store.db.Exec(store.query(`UPDATE %s SET accessed = %s WHERE sid = %s`, 2), time.Now().Unix(), sid)
+	return err
+}
+
+// GC removes sessions which were not accessed since before
+func (store *SQLStore) GC(before time.Time) error {
+	_, err := store.db.Exec(store.query(`DELETE FROM %s WHERE accessed < %s`, 1), before.Unix())
+	return err
+}
@@ -0,0 +1,177 @@
+package session
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver is database driver with single sessions table, it understands only queries
+// of SQLStore, update reports only changed rows like MySQL does
+type fakeDriver struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	data     string
+	accessed int64
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	var n int64
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO sessions (sid, data, accessed)"):
+		sid := args[0].(string)
+		if _, ok := s.d.rows[sid]; ok {
+			return nil, errors.New("duplicate primary key")
+		}
+		s.d.rows[sid] = fakeRow{args[1].(string), args[2].(int64)}
+		n = 1
+	case strings.HasPrefix(s.query, "UPDATE sessions SET data = ?, accessed = ? WHERE sid = ? AND data = ?"):
+		row, ok := s.d.rows[args[2].(string)]
+		if ok && row.data == args[3].(string) {
+			s.d.rows[args[2].(string)] = fakeRow{args[0].(string), args[1].(int64)}
+			n = 1
+		}
+	case strings.HasPrefix(s.query, "UPDATE sessions SET data = ?, accessed = ? WHERE sid = ?"):
+		row, ok := s.d.rows[args[2].(string)]
+		changed := fakeRow{args[0].(string), args[1].(int64)}
+		if ok && row != changed {
+			s.d.rows[args[2].(string)] = changed
+			n = 1
+		}
+	case strings.HasPrefix(s.query, "UPDATE sessions SET accessed = ? WHERE sid = ?"):
+		if row, ok := s.d.rows[args[1].(string)]; ok && row.accessed != args[0].(int64) {
+			row.accessed = args[0].(int64)
+			s.d.rows[args[1].(string)] = row
+			n = 1
+		}
+	case strings.HasPrefix(s.query, "DELETE FROM sessions WHERE sid = ?"):
+		delete(s.d.rows, args[0].(string))
+	case strings.HasPrefix(s.query, "DELETE FROM sessions WHERE accessed < ?"):
+		for sid, row := range s.d.rows {
+			if row.accessed < args[0].(int64) {
+				delete(s.d.rows, sid)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %s", s.query)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	rows := &fakeRows{}
+	row, ok := s.d.rows[args[0].(string)]
+	switch {
+	case strings.HasPrefix(s.query, "SELECT data, accessed FROM sessions WHERE sid = ?"):
+		rows.columns = []string{"data", "accessed"}
+		if ok {
+			rows.values = [][]driver.Value{{row.data, row.accessed}}
+		}
+	case strings.HasPrefix(s.query, "SELECT data FROM sessions WHERE sid = ?"):
+		rows.columns = []string{"data"}
+		if ok {
+			rows.values = [][]driver.Value{{row.data}}
+		}
+	case strings.HasPrefix(s.query, "SELECT 1 FROM sessions WHERE sid = ?"):
+		rows.columns = []string{"1"}
+		if ok {
+			rows.values = [][]driver.Value{{int64(1)}}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %s", s.query)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("fakesessions", &fakeDriver{rows: make(map[string]fakeRow)})
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("fakesessions", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewSQLStore(db, "sessions")
+	testStore(t, store)
+	testConcurrentSave(t, store)
+
+	// saving unchanged session updates zero rows, it must not be inserted again
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = store
+
+	s := New(newTestServer())
+	for i := 0; i < 2; i++ {
+		if err := store.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loaded, err := store.Get(s.ID()); err != nil || loaded == nil {
+		t.Fatal("Session not loaded from database", err)
+	}
+	if err := store.GC(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := store.Get(s.ID()); loaded != nil {
+		t.Fatal("GC did not remove session")
+	}
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is interface for session storage backends. MemoryStore shares session data between
+// concurrent requests of same client, so Update is atomic. Other stores load copy of session
+// for each request and merge changed keys with stored session on save, so concurrent requests
+// which change different keys don't lose changes. Loaded session is not saved again
+// if it was destroyed by concurrent request
+type Store interface {
+	// Get returns session with ID, returns nil session if there is no such session
+	Get(sid string) (*Session, error)
+	// Save stores session data
+	Save(session *Session) error
+	// Delete removes session from storage
+	Delete(sid string) error
+	// Touch marks session as accessed without changing its data
+	Touch(sid string) error
+	// GC removes sessions which were not accessed since before
+	GC(before time.Time) error
+}
+
+// DefaultStore is store used for sessions, by default sessions are kept in memory
+var DefaultStore Store = NewMemoryStore()
+
+type memoryEntry struct {
+	session  *Session
+	accessed time.Time
+}
+
+// MemoryStore keeps sessions in memory, sessions are lost on restart
+// and are not shared between instances
+type MemoryStore struct {
+	sessions map[string]*memoryEntry // todo: consider map[int] as it is rumored to be faster
+	lock     sync.RWMutex
+}
+
+// NewMemoryStore creates in-memory session store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*memoryEntry)}
+}
+
+// Get returns copy of session from memory, copies share session data,
+// so concurrent requests must use Get, Set, Delete and Update of session
+func (store *MemoryStore) Get(sid string) (*Session, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	if entry, ok := store.sessions[sid]; ok {
+		return entry.session.clone(), nil
+	}
+	return nil, nil
+}
+
+// Save stores session in memory, loaded session which was destroyed meanwhile is not stored
+func (store *MemoryStore) Save(session *Session) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.sessions[session.sid]; !ok && session.loaded {
+		return nil
+	}
+	session.locker()
+	store.sessions[session.sid] = &memoryEntry{session: session.clone(), accessed: time.Now()}
+	return nil
+}
+
+// Delete removes session from memory
+func (store *MemoryStore) Delete(sid string) error {
+	store.lock.Lock()
+	delete(store.sessions, sid)
+	store.lock.Unlock()
+	return nil
+}
+
+// Touch updates session access time
+func (store *MemoryStore) Touch(sid string) error {
+	store.lock.Lock()
+	if entry, ok := store.sessions[sid]; ok {
+		entry.accessed = time.Now()
+	}
+	store.lock.Unlock()
+	return nil
+}
+
+// GC removes sessions which were not accessed since before
+func (store *MemoryStore) GC(before time.Time) error {
+	store.lock.Lock()
+	for sid, entry := range store.sessions {
+		if entry.accessed.Before(before) {
+			delete(store.sessions, sid)
+		}
+	}
+	store.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,398 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/jzaikovs/t"
+)
+
+// testServer implements Server interface
+type testServer struct {
+	cookies map[string]string
+}
+
+func newTestServer() *testServer {
+	return &testServer{cookies: make(map[string]string)}
+}
+
+func (server *testServer) CookieValue(name string) (string, bool) {
+	value, ok := server.cookies[name]
+	return value, ok
+}
+
+func (server *testServer) SetCookieValue(name, value string) {
+	server.cookies[name] = value
+}
+
+func (server *testServer) UserAgent() string {
+	return "test"
+}
+
+func (server *testServer) RemoteAddr() string {
+	return "127.0.0.1"
+}
+
+func testStore(t *testing.T, store Store) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = store
+
+	server := newTestServer()
+
+	s := New(server)
+	s.Data["x"] = "y"
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(server)
+	if loaded.ID() != s.ID() || loaded.Data.Str("x") != "y" {
+		t.Fatal("Session not loaded from store")
+	}
+
+	oldID := loaded.ID()
+	loaded.Authorize("")
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ := store.Get(oldID); s != nil {
+		t.Fatal("Old session not removed on Authorize")
+	}
+
+	authorized := New(server)
+	if !authorized.IsAuth() || authorized.Data.Str("x") != "y" {
+		t.Fatal("Authorized session not loaded from store")
+	}
+
+	if err := store.GC(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ := store.Get(authorized.ID()); s == nil {
+		t.Fatal("GC removed active session")
+	}
+
+	authorized.Destroy()
+	authorized.Save()
+
+	if s, _ := store.Get(authorized.ID()); s != nil {
+		t.Fatal("Destroyed session found in store")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+// requests of same client get own copies of session in stores other than MemoryStore,
+// changes of different keys are merged on save and destroyed session is not saved again
+func testConcurrentSave(t *testing.T, store Store) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = store
+
+	server := newTestServer()
+	s := New(server)
+	s.Set("a", 1)
+	s.Set("b", 1)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := Get(server.cookies[SessionCookieName])
+	second, _ := Get(server.cookies[SessionCookieName])
+	first.Set("a", 2)
+	first.Delete("b")
+	second.Set("c", 3)
+	second.Grant("admin")
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, _ := Get(server.cookies[SessionCookieName])
+	if merged.Get("a").Int() != 2 || merged.Get("b").Value != nil || merged.Get("c").Int() != 3 {
+		t.Fatal("Concurrent changes not merged", merged.Data)
+	}
+
+	// parallel requests which change different keys
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, _ := Get(server.cookies[SessionCookieName])
+			s.Set(fmt.Sprint("key", i), i)
+			if err := s.Save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	merged, _ = Get(server.cookies[SessionCookieName])
+	for i := 0; i < 5; i++ {
+		if merged.Get(fmt.Sprint("key", i)).Value == nil {
+			t.Fatal("Change of parallel request lost", merged.Data)
+		}
+	}
+
+	destroyer, _ := Get(server.cookies[SessionCookieName])
+	destroyer.Destroy()
+	merged.Set("d", 4)
+	if err := merged.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := store.Get(merged.ID()); s != nil {
+		t.Fatal("Destroyed session saved again")
+	}
+}
+
+func TestConcurrentSave(t *testing.T) {
+	testConcurrentSave(t, NewMemoryStore())
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConcurrentSave(t, store)
+}
+
+// Redis store is tested only if SESSION_REDIS_ADDR is set, for example, 127.0.0.1:6379
+func TestRedisStore(t *testing.T) {
+	addr := os.Getenv("SESSION_REDIS_ADDR")
+	if len(addr) == 0 {
+		t.Skip("SESSION_REDIS_ADDR not set")
+	}
+	testStore(t, NewRedisStore(addr, time.Minute))
+}
+
+func TestExpiry(t *testing.T) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = NewMemoryStore()
+
+	defer func() { MaxLifetime, IdleTimeout = 0, 0 }()
+
+	server := newTestServer()
+	s := New(server)
+	sid := s.ID()
+
+	IdleTimeout = time.Hour
+	s.accessed = time.Now().Add(-2 * time.Hour)
+	if !s.Expired() {
+		t.Fatal("Idle session not expired")
+	}
+
+	s.Touch()
+	if s.Expired() {
+		t.Fatal("Touched session expired")
+	}
+
+	MaxLifetime = time.Hour
+	s.created = time.Now().Add(-2 * time.Hour)
+	s.Save()
+	if New(server).ID() == sid {
+		t.Fatal("Expired session returned")
+	}
+
+	if _, ok := Get(sid); ok {
+		t.Fatal("Expired session not removed")
+	}
+
+	renewed := New(server)
+	renewed.Data["x"] = "y"
+	renewed.created = time.Now().Add(-time.Minute)
+	renewed.Renew()
+	if time.Since(renewed.Created()) > time.Second || renewed.Data.Str("x") != "y" {
+		t.Fatal("Session not renewed")
+	}
+
+	DefaultStore.(*MemoryStore).sessions[renewed.ID()].accessed = time.Now().Add(-2 * time.Hour)
+	if err := GC(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Get(renewed.ID()); ok {
+		t.Fatal("GC did not remove expired session")
+	}
+}
+
+func TestSessionID(t *testing.T) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = NewMemoryStore()
+
+	defer func() { BindUserAgent, BindRemoteAddr = false, false }()
+
+	a, b := New(newTestServer()), New(newTestServer())
+	if a.ID() == b.ID() || len(a.ID()) != 43 {
+		t.Fatal("Session IDs are not random", a.ID(), b.ID())
+	}
+
+	BindUserAgent = true
+	server := newTestServer()
+	s := New(server)
+	if !s.Valid("", "test", "10.0.0.1") || s.Valid("", "other", "127.0.0.1") {
+		t.Fatal("Session not bound to user agent")
+	}
+
+	s.Authorize("key")
+	if !s.Valid("key", "test", "127.0.0.1") || s.Valid("", "test", "127.0.0.1") {
+		t.Fatal("Session key not validated")
+	}
+
+	// session created before random session IDs
+	legacy := &Session{sid: hash("key" + "test" + "127.0.0.1"), store: DefaultStore}
+	if !legacy.Valid("key", "test", "127.0.0.1") || legacy.Valid("", "test", "127.0.0.1") {
+		t.Fatal("Legacy session not validated")
+	}
+}
+
+func TestCookieStore(t *testing.T) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+
+	store, err := NewCookieStore(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DefaultStore = store
+
+	server := newTestServer()
+	s := New(server)
+	s.Data["x"] = "y"
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.cookies[SessionCookieName]; ok {
+		t.Fatal("Session ID cookie set for cookie sessions")
+	}
+
+	loaded := New(server)
+	if loaded.ID() != s.ID() || loaded.Data.Str("x") != "y" {
+		t.Fatal("Session not loaded from cookie")
+	}
+
+	// cookie encrypted with old key is accepted after key rotation
+	if DefaultStore, err = NewCookieStore(newKey, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if New(server).ID() != s.ID() {
+		t.Fatal("Session encrypted with old key not loaded")
+	}
+
+	// tampered cookie is rejected
+	value := []byte(server.cookies["session"])
+	value[len(value)-1] ^= 1
+	server.cookies["session"] = string(value)
+	if New(server).ID() == s.ID() {
+		t.Fatal("Tampered session accepted")
+	}
+
+	// large session is split in several cookies
+	big := New(server)
+	big.Data["big"] = strings.Repeat("x", 5000)
+	if err := big.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.cookies["session.1"]; !ok {
+		t.Fatal("Session not split in chunks")
+	}
+	if New(server).Data.Str("big") != big.Data.Str("big") {
+		t.Fatal("Chunked session not loaded")
+	}
+
+	big.Data["big"] = strings.Repeat("x", 50000)
+	if err := big.Save(); err != ErrSessionTooLarge {
+		t.Fatal("Too large session saved", err)
+	}
+
+	big.Destroy()
+	big.Save()
+	if len(server.cookies["session"]) > 0 || len(server.cookies["session.1"]) > 0 {
+		t.Fatal("Destroyed session cookies not cleared")
+	}
+}
+
+func TestFlash(t *testing.T) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = NewMemoryStore()
+
+	server := newTestServer()
+	s := New(server)
+	s.Flash("msg", "saved!")
+	s.Save()
+
+	// flash messages survive serialization
+	b, _ := s.MarshalJSON()
+	loaded := new(Session)
+	if err := loaded.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if flashes := loaded.Flashes("msg"); len(flashes) != 1 || flashes[0] != "saved!" {
+		t.Fatal("Flash message not returned", flashes)
+	}
+	if flashes := loaded.Flashes("msg"); len(flashes) != 0 {
+		t.Fatal("Flash message not removed")
+	}
+	if _, ok := loaded.Data[FlashKey]; ok {
+		t.Fatal("Empty flash namespace kept")
+	}
+}
+
+// run with -race, concurrent requests of same client get copies of one session
+func TestConcurrentData(t *testing.T) {
+	defer func(store Store) { DefaultStore = store }(DefaultStore)
+	DefaultStore = NewMemoryStore()
+
+	server := newTestServer()
+	New(server).Save()
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, ok := Get(server.cookies[SessionCookieName])
+			if !ok {
+				t.Error("Session not found")
+				return
+			}
+			s.Update(func(data Map) {
+				count, _ := data["count"].(int)
+				data["count"] = count + 1
+			})
+			s.Set(fmt.Sprint("key", i), i)
+			s.Grant(fmt.Sprint("role", i))
+			s.Allow("read")
+			s.HasRole("role0")
+			s.Flash("msg", i)
+			s.Get("count")
+			s.Delete(fmt.Sprint("key", i))
+			if err := s.Save(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s, _ := Get(server.cookies[SessionCookieName])
+	if s.Get("count").Int() != n || len(s.Flashes("msg")) != n {
+		t.Fatal("Concurrent updates lost", s.Get("count"))
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jzaikovs/tokenbucket"
+)
+
+// Throttle protects login routes from brute-force attacks, failed attempts are counted
+// per identifier (user name, e-mail, ...) and per IP address using token buckets,
+// when bucket is empty identifier or IP is locked out, each next lockout is twice as long.
+// Buckets are rebuilt each period or when MaxKeys failed attempts are counted, so keys sent
+// by attacker don't grow memory without limit, lockouts are kept when buckets are rebuilt
+type Throttle struct {
+	perID *tokenbucket.Buckets
+	perIP *tokenbucket.Buckets
+
+	// BaseDelay is first lockout time, MaxDelay limits lockout time
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxKeys limits number of failed attempts counted before buckets are rebuilt
+	MaxKeys int
+
+	idAttempts, ipAttempts int
+	per                    time.Duration
+	counted                int       // failed attempts counted since buckets were built
+	built                  time.Time // when buckets were built
+
+	lockouts map[string]*lockout
+	lock     sync.Mutex
+	now      func() time.Time // clock used by middleware
+}
+
+type lockout struct {
+	strikes int // number of lockouts in row
+	until   time.Time
+}
+
+// NewThrottle creates throttle which allows idAttempts failed attempts for identifier and
+// ipAttempts failed attempts from IP address per period
+func NewThrottle(idAttempts, ipAttempts int, per time.Duration) *Throttle {
+	return &Throttle{
+		BaseDelay:  time.Second,
+		MaxDelay:   time.Hour,
+		MaxKeys:    100000,
+		idAttempts: idAttempts,
+		ipAttempts: ipAttempts,
+		per:        per,
+		lockouts:   make(map[string]*lockout),
+		now:        time.Now,
+	}
+}
+
+// buckets rebuilds buckets if they are older than period or count too many attempts
+func (throttle *Throttle) buckets(now time.Time) {
+	if throttle.perID != nil && now.Sub(throttle.built) < throttle.per && throttle.counted < throttle.MaxKeys {
+		return
+	}
+	rate := float32(1 / throttle.per.Seconds())
+	throttle.perID = tokenbucket.NewBuckets(throttle.idAttempts, float32(throttle.idAttempts)*rate)
+	throttle.perIP = tokenbucket.NewBuckets(throttle.ipAttempts, float32(throttle.ipAttempts)*rate)
+	throttle.counted = 0
+	throttle.built = now
+}
+
+// Allowed returns false and time to wait if identifier or IP address is locked out
+func (throttle *Throttle) Allowed(id, ip string, now time.Time) (time.Duration, bool) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	var wait time.Duration
+	for _, key := range []string{"id:" + id, "ip:" + ip} {
+		if l, ok := throttle.lockouts[key]; ok && l.until.After(now) && l.until.Sub(now) > wait {
+			wait = l.until.Sub(now)
+		}
+	}
+	return wait, wait == 0
+}
+
+// Fail counts failed attempt, returns remaining attempts for identifier
+func (throttle *Throttle) Fail(id, ip string, now time.Time) int {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+
+	throttle.buckets(now)
+	throttle.counted++
+
+	remaining, ok := throttle.perID.Add(id, now)
+	if !ok {
+		throttle.lockout("id:"+id, now)
+	}
+	if _, ok := throttle.perIP.Add(ip, now); !ok {
+		throttle.lockout("ip:"+ip, now)
+	}
+	return remaining
+}
+
+// Succeed resets lockouts of identifier after successful attempt, IP address lockouts are kept
+func (throttle *Throttle) Succeed(id string) {
+	throttle.lock.Lock()
+	delete(throttle.lockouts, "id:"+id)
+	throttle.lock.Unlock()
+}
+
+// lockout locks key out with exponential backoff, strikes are forgotten
+// if key was not locked out for MaxDelay
+func (throttle *Throttle) lockout(key string, now time.Time) {
+	l, ok := throttle.lockouts[key]
+	if !ok || now.Sub(l.until) > throttle.MaxDelay {
+		l = new(lockout)
+		throttle.lockouts[key] = l
+	}
+
+	delay := throttle.BaseDelay << uint(l.strikes)
+	if delay > throttle.MaxDelay || delay <= 0 {
+		delay = throttle.MaxDelay
+	} else {
+		l.strikes++
+	}
+	l.until = now.Add(delay)
+
+	// forget old lockouts, so map does not grow without limit
+	for k, l := range throttle.lockouts {
+		if now.Sub(l.until) > throttle.MaxDelay {
+			delete(throttle.lockouts, k)
+		}
+	}
+}
+
+// Middleware returns middleware for login routes, identifier is read from request data field,
+// response with status 401 or 403 counts as failed attempt, 2xx response as successful,
+// locked out requests get 429 response with Retry-After header
+func (throttle *Throttle) Middleware(field string) Middleware {
+	return func(next RouteFunc) RouteFunc {
+		return func(context Context) {
+			id := strings.ToLower(context.Data().Str(field))
+			ip := context.RemoteAddr()
+
+			if wait, ok := throttle.Allowed(id, ip, throttle.now()); !ok {
+				context.AddHeader("Retry-After", int((wait+time.Second-1)/time.Second))
+				context.Response(Response_Too_Many_Requests)
+				return
+			}
+
+			next(context)
+
+			switch code := context.ResponseCode(); {
+			case code == Response_Unauthorized || code == Response_Forbidden:
+				remaining := throttle.Fail(id, ip, throttle.now())
+				context.AddHeader(HeaderXRateLimit, throttle.idAttempts)
+				context.AddHeader(HeaderXRateLimitRemaining, remaining)
+			case code >= 200 && code < 300:
+				throttle.Succeed(id)
+			}
+		}
+	}
+}
+
+// Throttle protects route from brute-force attacks, see Throttle.Middleware,
+// throttle is checked before route middlewares
+func (route *Route) Throttle(throttle *Throttle, field string) *Route {
+	route.throttle = throttle.Middleware(field)
+	route.options |= optionThrottle
+	return route
+}
+
+// Throttle protects all routes in group with same throttle, so failed attempts on login
+// and password reset routes are counted together, see Throttle.Middleware
+func (group *Group) Throttle(throttle *Throttle, field string) *Group {
+	group.set(optionThrottle, func(r *Route) { r.Throttle(throttle, field) })
+	return group
+}
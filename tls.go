@@ -0,0 +1,102 @@
+package core
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/jzaikovs/core/loggy"
+)
+
+// certReloader holds TLS certificate which can be reloaded from disk without restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload loads certificate from disk, on error previous certificate is kept
+func (reloader *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.lock.Lock()
+	reloader.cert = &cert
+	reloader.lock.Unlock()
+	return nil
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.cert, nil
+}
+
+// watch reloads certificate on SIGHUP until done is closed
+func (reloader *certReloader) watch(done chan struct{}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-c:
+			if err := reloader.reload(); err != nil {
+				loggy.Error.Println("TLS certificate reload failed:", err)
+			} else {
+				loggy.Info.Println("TLS certificate reloaded")
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// tlsConfig creates TLS configuration for server, returns nil if TLS is not configured
+func (config *configStruct) tlsConfig() (*tls.Config, *certReloader, error) {
+	if len(config.TLSCert) == 0 || len(config.TLSKey) == 0 {
+		return nil, nil, nil
+	}
+
+	reloader, err := newCertReloader(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	return tlsConfig, reloader, nil
+}
+
+// redirectHandler redirects all requests to same URL on HTTPS port
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jzaikovs/t"
+)
+
+// named constraints that can be used in route parameters, for example /users/:id<int> or /users/{id:int}
+// any other constraint value is compiled as regular expression, for example /:code<[a-z]{2}>
+var paramConstraints = map[string]string{
+	"int":   `-?\d+`,
+	"uint":  `\d+`,
+	"alpha": `[a-zA-Z]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"hex":   `[a-fA-F0-9]+`,
+	"uuid":  `[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}`,
+}
+
+// characters that mark static segment as regular expression
+const regexpMeta = `\.+*?()|[]{}^$`
+
+// named parameter in braces, {name}, is also supported in regular expression patterns
+var braceParam = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// compilePattern compiles regular expression route pattern,
+// before compiling {name} parameters are replaced with named groups
+func compilePattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(braceParam.ReplaceAllString(pattern, `(?P<$1>[^/]+)`))
+}
+
+type segmentKind int
+
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentCatchAll
+)
+
+// segment is single part of route pattern between slashes
+type segment struct {
+	kind       segmentKind
+	value      string // static value or parameter name
+	constraint string // constraint as written in pattern
+	check      *regexp.Regexp
+}
+
+func (seg segment) match(value string) bool {
+	return len(value) > 0 && (seg.check == nil || seg.check.MatchString(value))
+}
+
+// splitPath splits path in segments, same way for patterns and request paths
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// parsePattern parses route pattern in segments for prefix tree,
+// returns false if pattern must be handled as regular expression.
+// Static patterns are stored in tree only if they are anchored, like ^/users$, because
+// unanchored patterns match anywhere in request URI, same as any other regular expression
+func parsePattern(pattern string) ([]segment, bool) {
+	// anchored regular expressions without any other special characters are static paths
+	anchored := strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, false
+	}
+
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+
+	for i, part := range parts {
+		seg, ok := parseSegment(part)
+		if !ok {
+			return nil, false
+		}
+
+		// catch-all can be only last segment
+		if seg.kind == segmentCatchAll && i != len(parts)-1 {
+			return nil, false
+		}
+
+		segments = append(segments, seg)
+		anchored = anchored || seg.kind != segmentStatic
+	}
+
+	// patterns with parameters always match whole path
+	return segments, anchored
+}
+
+func parseSegment(part string) (seg segment, ok bool) {
+	switch {
+	case strings.HasPrefix(part, ":"):
+		seg.kind = segmentParam
+		seg.value = part[1:]
+
+		// typed constraint, :name<constraint>
+		if i := strings.Index(seg.value, "<"); i >= 0 {
+			if !strings.HasSuffix(seg.value, ">") {
+				return seg, false
+			}
+			seg.constraint = seg.value[i+1 : len(seg.value)-1]
+			seg.value = seg.value[:i]
+		}
+
+		return seg, seg.compile()
+
+	case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+		// same as above, only written as {name} or {name:constraint}
+		seg.kind = segmentParam
+		seg.value = part[1 : len(part)-1]
+
+		if i := strings.Index(seg.value, ":"); i >= 0 {
+			seg.constraint = seg.value[i+1:]
+			seg.value = seg.value[:i]
+		}
+
+		return seg, seg.compile()
+
+	case strings.HasPrefix(part, "*"):
+		seg.kind = segmentCatchAll
+		seg.value = part[1:]
+		return seg, validParamName(seg.value)
+	}
+
+	seg.kind = segmentStatic
+	seg.value = part
+	return seg, !strings.ContainsAny(part, regexpMeta)
+}
+
+// compile validates parameter name and compiles its constraint
+func (seg *segment) compile() bool {
+	if !validParamName(seg.value) {
+		return false
+	}
+
+	if len(seg.constraint) == 0 {
+		return true
+	}
+
+	expr, ok := paramConstraints[seg.constraint]
+	if !ok {
+		expr = seg.constraint
+	}
+
+	check, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return false
+	}
+
+	seg.check = check
+	return true
+}
+
+func validParamName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// node is single node in routing prefix tree,
+// each node represents one segment of path
+type node struct {
+	seg      segment
+	static   map[string]*node
+	params   []*node // parameter children, tried in order they was added
+	catchAll *node
+	route    *Route
+}
+
+func newNode(seg segment) *node {
+	return &node{seg: seg, static: make(map[string]*node)}
+}
+
+// insert adds route to tree, returns error if there already is route for same path
+func (n *node) insert(segments []segment, route *Route) error {
+	for _, seg := range segments {
+		n = n.child(seg)
+	}
+
+	if n.route != nil {
+		return fmt.Errorf("route %s %s conflicts with %s", route.method, route.patternStr, n.route.patternStr)
+	}
+
+	n.route = route
+	return nil
+}
+
+// child returns existing child node for segment or creates new one
+func (n *node) child(seg segment) *node {
+	switch seg.kind {
+	case segmentParam:
+		for _, child := range n.params {
+			if child.seg.value == seg.value && child.seg.constraint == seg.constraint {
+				return child
+			}
+		}
+		child := newNode(seg)
+		n.params = append(n.params, child)
+		return child
+
+	case segmentCatchAll:
+		if n.catchAll == nil {
+			n.catchAll = newNode(seg)
+		}
+		return n.catchAll
+	}
+
+	child, ok := n.static[seg.value]
+	if !ok {
+		child = newNode(seg)
+		n.static[seg.value] = child
+	}
+	return child
+}
+
+// lookup finds route for path segments, static segments have priority over parameters
+// and parameters have priority over catch-all, captured values are returned as arguments
+func (n *node) lookup(parts []string, args []t.T) (*Route, []t.T) {
+	if len(parts) == 0 {
+		return n.route, args
+	}
+
+	part, rest := parts[0], parts[1:]
+
+	if child, ok := n.static[part]; ok {
+		if route, found := child.lookup(rest, args); route != nil {
+			return route, found
+		}
+	}
+
+	// parameters are matched and returned unescaped, same as they are passed to Router.URL
+	if len(n.params) > 0 {
+		if value, err := url.PathUnescape(part); err == nil {
+			for _, child := range n.params {
+				if !child.seg.match(value) {
+					continue
+				}
+				if route, found := child.lookup(rest, append(args, t.T{Value: value})); route != nil {
+					return route, found
+				}
+			}
+		}
+	}
+
+	if n.catchAll != nil && n.catchAll.route != nil {
+		if value, err := url.PathUnescape(strings.Join(parts, "/")); err == nil {
+			return n.catchAll.route, append(args, t.T{Value: value})
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp/syntax"
+	"strings"
+)
+
+var errRouteNotFound = errors.New("route not found")
+
+// URL builds URL path for named route in router, parameters are passed as name and value pairs,
+// parameters which are not in route pattern are added as query string
+func (router *defaultRouter) URL(name string, params ...interface{}) (string, error) {
+	for _, r := range router.routes {
+		if r.name == name {
+			return r.URL(params...)
+		}
+	}
+	return "", fmt.Errorf("core: %w: %s", errRouteNotFound, name)
+}
+
+// URL builds URL for named route, it searches application and it's sub-applications,
+// result includes sub-application prefix and base URL from configuration
+func (app *App) URL(name string, params ...interface{}) (string, error) {
+	path, err := app.path(name, params)
+	if err != nil {
+		return "", err
+	}
+
+	config := app.Config
+	if config == nil {
+		config = DefaultConfig
+	}
+
+	prefix := strings.TrimRight(config.BaseURL, "/")
+	if subdir := strings.Trim(config.Subdir, "/"); len(subdir) > 0 && !strings.HasSuffix(prefix, "/"+subdir) {
+		prefix += "/" + subdir
+	}
+
+	return prefix + path, nil
+}
+
+func (app *App) path(name string, params []interface{}) (string, error) {
+	path, err := app.Router.URL(name, params...)
+	if err == nil || !errors.Is(err, errRouteNotFound) {
+		return path, err
+	}
+
+	for prefix, sub := range app.subs {
+		if path, err := sub.path(name, params); err == nil || !errors.Is(err, errRouteNotFound) {
+			return "/" + prefix + path, err
+		}
+	}
+
+	return "", err
+}
+
+// URL builds URL path for route, parameters are passed as name and value pairs
+func (route *Route) URL(params ...interface{}) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("core: route %s: odd number of parameters", route.patternStr)
+	}
+
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[fmt.Sprint(params[i])] = fmt.Sprint(params[i+1])
+	}
+
+	var path string
+	var err error
+
+	if route.pattern == nil {
+		path, err = route.buildFromSegments(values)
+	} else {
+		path, err = route.buildFromRegexp(values)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// all not used values goes to query string
+	if len(values) > 0 {
+		query := url.Values{}
+		for k, v := range values {
+			query.Set(k, v)
+		}
+		path += "?" + query.Encode()
+	}
+
+	return path, nil
+}
+
+func (route *Route) param(values map[string]string, name string) (string, error) {
+	value, ok := values[name]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("core: route %s: missing parameter [%s]", route.patternStr, name)
+	}
+	delete(values, name)
+	return value, nil
+}
+
+func (route *Route) buildFromSegments(values map[string]string) (string, error) {
+	parts := make([]string, len(route.segments))
+
+	for i, seg := range route.segments {
+		if seg.kind == segmentStatic {
+			parts[i] = seg.value
+			continue
+		}
+
+		value, err := route.param(values, seg.value)
+		if err != nil {
+			return "", err
+		}
+
+		if seg.kind == segmentCatchAll {
+			parts[i] = value
+			continue
+		}
+
+		if !seg.match(value) {
+			return "", fmt.Errorf("core: route %s: parameter [%s] does not match %s", route.patternStr, seg.value, seg.constraint)
+		}
+		parts[i] = url.PathEscape(value)
+	}
+
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+// buildFromRegexp builds path from regular expression pattern,
+// only literals and named groups are supported
+func (route *Route) buildFromRegexp(values map[string]string) (string, error) {
+	re, err := syntax.Parse(route.pattern.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(strings.Builder)
+	if err := route.buildRegexpNode(buf, re.Simplify(), values); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (route *Route) buildRegexpNode(buf *strings.Builder, re *syntax.Regexp, values map[string]string) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return nil
+	case syntax.OpLiteral:
+		buf.WriteString(string(re.Rune))
+		return nil
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := route.buildRegexpNode(buf, sub, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case syntax.OpCapture:
+		if len(re.Name) == 0 {
+			break
+		}
+		value, err := route.param(values, re.Name)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(url.PathEscape(value))
+		return nil
+	}
+
+	return fmt.Errorf("core: route %s: can't build URL from pattern", route.patternStr)
+}